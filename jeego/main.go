@@ -1,15 +1,23 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
 	"runtime"
-	"strings"
 
 	"github.com/aymerick/jeego/pkg/app"
-	"github.com/aymerick/jeego/pkg/serial_reader"
 
 	log "code.google.com/p/log4go"
 )
 
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  jeego                                       Run server\n")
+	fmt.Fprintf(os.Stderr, "  jeego replay [-realtime] [-db <path>] <file> Replay a RF12demo log file\n")
+	fmt.Fprintf(os.Stderr, "  jeego db migrate [--dry-run] [-db <path>]    Migrate database schema\n")
+}
+
 func main() {
 	// init Jeego Server
 	jeego := app.NewJeego()
@@ -23,6 +31,28 @@ func main() {
 	// debug
 	jeego.DumpConfig()
 
+	jeego.SetupNodeKinds()
+	jeego.SetupPlausibilityRules()
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			replay(jeego, os.Args[2:])
+		case "db":
+			database(jeego, os.Args[2:])
+		default:
+			usage()
+			os.Exit(1)
+		}
+	} else {
+		serve(jeego)
+	}
+
+	log.Close()
+}
+
+// run server
+func serve(jeego *app.Jeego) {
 	jeego.SetupDatabase()
 
 	// save nodes values to database every 5mn
@@ -40,20 +70,88 @@ func main() {
 	// start RF12 handler
 	handlerChan := jeego.StartRf12demo()
 
-	// serial reader
-	serial_reader := serial_reader.New(jeego.Config.SerialPort, jeego.Config.SerialBaud)
-
-	log.Info("Reading on serial port: %+v", jeego.Config.SerialPort)
+	// start gateways
+	jeego.StartGateways(handlerChan)
 
 	// loop forever
-	for {
-		// read a line and trim it
-		line := strings.Trim(serial_reader.ReadLine(), " \n\r")
-		if line != "" {
-			log.Debug("Received: %s", line)
-
-			// send line to handler
-			handlerChan <- line
+	select {}
+}
+
+// replay a RF12demo log file
+func replay(jeego *app.Jeego, args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = usage
+
+	realtime := flags.Bool("realtime", false, "Replay at recorded speed")
+	databasePath := flags.String("db", "", "Database path (default to the one in config file)")
+
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+		os.Exit(1)
+	}
+
+	if *databasePath != "" {
+		jeego.Config.DatabasePath = *databasePath
+	}
+
+	jeego.SetupDatabase()
+
+	// wait for all writes to be done before exiting
+	jeego.Database.SetSync(true)
+
+	if err := jeego.Replay(flags.Arg(0), *realtime); err != nil {
+		log.Critical(err)
+		log.Close()
+		os.Exit(1)
+	}
+}
+
+// run a database command
+func database(jeego *app.Jeego, args []string) {
+	if (len(args) == 0) || (args[0] != "migrate") {
+		usage()
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = usage
+
+	dryRun := flags.Bool("dry-run", false, "List pending migrations without applying them")
+	databasePath := flags.String("db", "", "Database path (default to the one in config file)")
+
+	flags.Parse(args[1:])
+	if flags.NArg() != 0 {
+		usage()
+		os.Exit(1)
+	}
+
+	if *databasePath != "" {
+		jeego.Config.DatabasePath = *databasePath
+	}
+
+	db, err := app.OpenDatabase(jeego.Config.DatabasePath)
+	if err == nil {
+		var migrations []*app.Migration
+
+		if *dryRun {
+			migrations, err = db.PendingMigrations()
+		} else {
+			migrations, err = db.Migrate()
+		}
+
+		for _, migration := range migrations {
+			fmt.Printf("%d: %s\n", migration.Version, migration.Name)
 		}
+
+		if (err == nil) && (len(migrations) == 0) {
+			fmt.Printf("Database schema is up to date\n")
+		}
+	}
+
+	if err != nil {
+		log.Critical(err)
+		log.Close()
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	BATTERY_VCC_LOW          = 2400 // in mV, below that battery is low
+	BATTERY_VCC_EMPTY        = 2200 // in mV, below that node stops working
+	BATTERY_REPLACEMENT_JUMP = 200  // in mV, a VCC increase that big means that battery was replaced
+	BATTERY_TREND_MIN_SPAN   = 6    // in hours, minimum logs time span to compute VCC trend
+)
+
+// Battery replacement, detected by a VCC jump
+type BatteryReplacement struct {
+	NodeId    int       `json:"node_id"`
+	At        time.Time `json:"at"`
+	VccBefore int       `json:"vcc_before"`
+	VccAfter  int       `json:"vcc_after"`
+}
+
+// Battery status of a node
+type BatteryStatus struct {
+	NodeId     int    `json:"node_id"`
+	Name       string `json:"name"`
+	LowBattery bool   `json:"low_battery"`
+
+	Vcc           int        `json:"vcc,omitempty"`            // in mV
+	Trend         *float64   `json:"trend,omitempty"`          // in mV per day
+	DaysRemaining *float64   `json:"days_remaining,omitempty"` // before VCC reaches BATTERY_VCC_EMPTY
+	ReplacedAt    *time.Time `json:"replaced_at,omitempty"`
+}
+
+// Returns true if node reports its battery state
+func (node *Node) haveBattery() bool {
+	return node.sensorEnabled(VCC_SENSOR) || node.sensorEnabled(LOWBAT_SENSOR)
+}
+
+// Returns true if node battery is low
+func (node *Node) lowBattery() bool {
+	if node.sensorEnabled(LOWBAT_SENSOR) && (node.Values[LOWBAT_SENSOR] != 0) {
+		return true
+	}
+
+	if vcc, found := node.Values[VCC_SENSOR]; found && node.sensorEnabled(VCC_SENSOR) && (vcc > 0) && (vcc < BATTERY_VCC_LOW) {
+		return true
+	}
+
+	return false
+}
+
+// Compute battery status of given node, from its logs since last battery replacement
+func computeBatteryStatus(node *Node, nodeLogs []*NodeLog, replacements []*BatteryReplacement) *BatteryStatus {
+	result := &BatteryStatus{
+		NodeId:     node.Id,
+		Name:       node.Name,
+		LowBattery: node.lowBattery(),
+	}
+
+	var since time.Time
+	if len(replacements) > 0 {
+		since = replacements[len(replacements)-1].At
+		result.ReplacedAt = &since
+	}
+
+	if !node.sensorEnabled(VCC_SENSOR) {
+		return result
+	}
+
+	result.Vcc = int(node.Values[VCC_SENSOR])
+
+	// VCC trend, with a linear regression
+	points := make([][2]float64, 0)
+	for _, nodeLog := range nodeLogs {
+		vcc, found := nodeLog.Values[VCC_SENSOR]
+		if found && (vcc > 0) && !nodeLog.At.Before(since) {
+			points = append(points, [2]float64{float64(nodeLog.At.Unix()) / 86400, vcc})
+		}
+	}
+
+	if (len(points) < 2) || ((points[len(points)-1][0]-points[0][0])*24 < BATTERY_TREND_MIN_SPAN) {
+		return result
+	}
+
+	slope, ok := linearRegressionSlope(points)
+	if !ok {
+		return result
+	}
+
+	trend := math.Floor(slope*10+0.5) / 10
+	result.Trend = &trend
+
+	if slope < 0 {
+		days := math.Max(0, (float64(result.Vcc)-BATTERY_VCC_EMPTY)/-slope)
+		days = math.Floor(days*10+0.5) / 10
+
+		result.DaysRemaining = &days
+	}
+
+	return result
+}
+
+// Returns slope of least squares line through given points
+func linearRegressionSlope(points [][2]float64) (float64, bool) {
+	n := float64(len(points))
+
+	sumX, sumY, sumXY, sumXX := 0.0, 0.0, 0.0, 0.0
+	for _, point := range points {
+		sumX += point[0]
+		sumY += point[1]
+		sumXY += point[0] * point[1]
+		sumXX += point[0] * point[0]
+	}
+
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return 0, false
+	}
+
+	return (n*sumXY - sumX*sumY) / denominator, true
+}
+
+// Returns battery status of all nodes with a battery, most urgent first
+func (jeego *Jeego) BatteriesStatus() []*BatteryStatus {
+	result := make([]*BatteryStatus, 0)
+
+	for _, node := range jeego.Database.nodes {
+		if node.haveBattery() {
+			result = append(result, computeBatteryStatus(node, jeego.Database.nodeLogs(node), jeego.Database.batteryReplacements(node)))
+		}
+	}
+
+	return sortedBatteries(result)
+}
+
+// Returns given batteries status, most urgent first
+func sortedBatteries(batteries []*BatteryStatus) []*BatteryStatus {
+	sort.Sort(batteriesByUrgency(batteries))
+
+	return batteries
+}
+
+// Track battery state changes of given node
+func (jeego *Jeego) checkBattery(node *Node, previousVcc float64, previousLow bool, at time.Time) {
+	if node.sensorEnabled(VCC_SENSOR) && (previousVcc > 0) && (node.Values[VCC_SENSOR]-previousVcc >= BATTERY_REPLACEMENT_JUMP) {
+		replacement := &BatteryReplacement{
+			NodeId:    node.Id,
+			At:        at,
+			VccBefore: int(previousVcc),
+			VccAfter:  int(node.Values[VCC_SENSOR]),
+		}
+
+		node.LogWarn(fmt.Sprintf("Battery replaced: %d mV => %d mV", replacement.VccBefore, replacement.VccAfter))
+
+		jeego.Database.insertBatteryReplacement(replacement)
+
+		jeego.publishEvent(&Event{
+			Kind:   BATTERY_REPLACED_EVENT,
+			NodeId: node.Id,
+			At:     at,
+			Data:   map[string]interface{}{"vcc_before": replacement.VccBefore, "vcc_after": replacement.VccAfter},
+		})
+	}
+
+	low := node.lowBattery()
+	if low != previousLow {
+		kind := BATTERY_OK_EVENT
+		if low {
+			kind = BATTERY_LOW_EVENT
+
+			node.LogWarn("Low battery")
+		}
+
+		jeego.publishEvent(&Event{Kind: kind, NodeId: node.Id, At: at})
+	}
+}
+
+// sort helper: low batteries first, then by days remaining
+type batteriesByUrgency []*BatteryStatus
+
+func (a batteriesByUrgency) Len() int      { return len(a) }
+func (a batteriesByUrgency) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a batteriesByUrgency) Less(i, j int) bool {
+	if a[i].LowBattery != a[j].LowBattery {
+		return a[i].LowBattery
+	}
+
+	if (a[i].DaysRemaining != nil) && (a[j].DaysRemaining != nil) && (*a[i].DaysRemaining != *a[j].DaysRemaining) {
+		return *a[i].DaysRemaining < *a[j].DaysRemaining
+	}
+
+	if (a[i].DaysRemaining == nil) != (a[j].DaysRemaining == nil) {
+		return a[i].DaysRemaining != nil
+	}
+
+	return a[i].NodeId < a[j].NodeId
+}
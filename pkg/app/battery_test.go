@@ -0,0 +1,118 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ComputeBatteryStatus(t *testing.T) {
+	node := &Node{Id: 3, Kind: TINYTX_T_NODE, Name: "Cellar", Values: map[Sensor]float64{VCC_SENSOR: 2700}}
+
+	startAt := time.Date(2014, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// VCC drops by 50 mV per day
+	nodeLogs := make([]*NodeLog, 0)
+	for i := 0; i <= 48; i++ {
+		nodeLogs = append(nodeLogs, &NodeLog{
+			NodeId: 3,
+			At:     startAt.Add(time.Hour * time.Duration(i)),
+			Values: map[Sensor]float64{VCC_SENSOR: 2800 - 50*float64(i)/24},
+		})
+	}
+
+	status := computeBatteryStatus(node, nodeLogs, nil)
+	assert.Equal(t, status.Vcc, 2700)
+	assert.False(t, status.LowBattery)
+	assert.Equal(t, *status.Trend, float64(-50))
+	assert.Equal(t, *status.DaysRemaining, float64(10))
+	assert.Nil(t, status.ReplacedAt)
+
+	// logs before battery replacement are ignored
+	replacedAt := startAt.Add(time.Hour * 44)
+	status = computeBatteryStatus(node, nodeLogs, []*BatteryReplacement{{NodeId: 3, At: replacedAt, VccBefore: 2300, VccAfter: 2900}})
+	assert.Nil(t, status.Trend)
+	assert.Nil(t, status.DaysRemaining)
+	assert.Equal(t, *status.ReplacedAt, replacedAt)
+
+	// low battery flag
+	node = &Node{Id: 2, Kind: JEENODE_THLM_NODE, Values: map[Sensor]float64{LOWBAT_SENSOR: 1}}
+
+	status = computeBatteryStatus(node, nil, nil)
+	assert.True(t, status.LowBattery)
+	assert.Equal(t, status.Vcc, 0)
+	assert.Nil(t, status.Trend)
+}
+
+func Test_BatteriesUrgency(t *testing.T) {
+	days := func(value float64) *float64 { return &value }
+
+	batteries := []*BatteryStatus{
+		{NodeId: 2},
+		{NodeId: 3, DaysRemaining: days(40)},
+		{NodeId: 4, DaysRemaining: days(12)},
+		{NodeId: 5, LowBattery: true},
+	}
+
+	result := make([]int, 0)
+	for _, status := range sortedBatteries(batteries) {
+		result = append(result, status.NodeId)
+	}
+
+	assert.Equal(t, result, []int{5, 4, 3, 2})
+}
+
+func Test_BatteryEvents(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Database: db, Events: NewEventBus()}
+	eventsChan := jeego.Events.Subscribe(BATTERY_LOW_EVENT, BATTERY_REPLACED_EVENT)
+	allEventsChan := jeego.Events.Subscribe()
+
+	node := &Node{Kind: TINYTX_T_NODE}
+	at := time.Now().UTC()
+
+	handle := func(vcc uint64) {
+		at = at.Add(time.Minute)
+
+		_, err := jeego.handleDataLog(&Rf12demoDataLog{nodeId: 3, nodeKind: TINYTX_T_NODE, data: node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 120, VCC_SENSOR: vcc}), at: at})
+		assert.Nil(t, err)
+	}
+
+	handle(2500)
+	handle(2450)
+	assert.Equal(t, len(eventsChan), 0)
+	assert.Equal(t, len(allEventsChan), 0)
+
+	handle(2350)
+	assert.Equal(t, len(eventsChan), 1)
+
+	event := <-eventsChan
+	assert.Equal(t, event.Kind, BATTERY_LOW_EVENT)
+	assert.Equal(t, event.NodeId, 3)
+	assert.Equal(t, event.At, at)
+	assert.Equal(t, (<-allEventsChan).Kind, BATTERY_LOW_EVENT)
+
+	// new battery
+	handle(3100)
+	assert.Equal(t, len(eventsChan), 1)
+
+	event = <-eventsChan
+	assert.Equal(t, event.Kind, BATTERY_REPLACED_EVENT)
+	assert.Equal(t, event.Data, map[string]interface{}{"vcc_before": 2350, "vcc_after": 3100})
+
+	assert.Equal(t, len(allEventsChan), 2)
+	assert.Equal(t, (<-allEventsChan).Kind, BATTERY_REPLACED_EVENT)
+	assert.Equal(t, (<-allEventsChan).Kind, BATTERY_OK_EVENT)
+
+	replacements := db.batteryReplacements(db.NodeForId(3))
+	assert.Equal(t, len(replacements), 1)
+	assert.Equal(t, *replacements[0], BatteryReplacement{NodeId: 3, At: at.Truncate(time.Second), VccBefore: 2350, VccAfter: 3100})
+
+	statuses := jeego.BatteriesStatus()
+	assert.Equal(t, len(statuses), 1)
+	assert.Equal(t, statuses[0].Vcc, 3100)
+	assert.Equal(t, *statuses[0].ReplacedAt, at.Truncate(time.Second))
+}
@@ -0,0 +1,295 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// sensors calibrated if none specified
+var CALIBRATION_DEFAULT_SENSORS = []Sensor{TEMP_SENSOR, HUMI_SENSOR}
+
+// Calibration session: nodes are put in the same place, and their logged values are compared
+// to compute sensors shift corrections
+//
+// Values before shift correction are compared to the median of all nodes values logged at the
+// same time, or to reference node values if set.
+type Calibration struct {
+	NodesIds        []int
+	ReferenceNodeId int // 0 if not set
+	Sensors         []Sensor
+	StartedAt       time.Time
+	StoppedAt       time.Time
+}
+
+// Calibration result for a node sensor
+type CalibrationResult struct {
+	NodeId    int     `json:"node_id"`
+	Sensor    string  `json:"sensor"`
+	SamplesNb int     `json:"samples_nb"`
+	Offset    float64 `json:"offset"`   // offset to apply
+	Error     float64 `json:"error"`    // RMS error before correction
+	Residual  float64 `json:"residual"` // RMS error after correction
+
+	sensor Sensor
+}
+
+// Start a new calibration session
+func NewCalibration(db *Database, nodesIds []int, referenceNodeId int, sensors []Sensor, at time.Time) (*Calibration, error) {
+	if len(nodesIds) < 2 {
+		return nil, fmt.Errorf("At least two nodes are needed for calibration")
+	}
+
+	seen := make(map[int]bool)
+	for _, nodeId := range nodesIds {
+		if db.NodeForId(nodeId) == nil {
+			return nil, fmt.Errorf("Node %d not found", nodeId)
+		}
+
+		if seen[nodeId] {
+			return nil, fmt.Errorf("Node %d given twice", nodeId)
+		}
+		seen[nodeId] = true
+	}
+
+	if (referenceNodeId != 0) && !seen[referenceNodeId] {
+		return nil, fmt.Errorf("Reference node %d is not calibrated", referenceNodeId)
+	}
+
+	if len(sensors) == 0 {
+		sensors = CALIBRATION_DEFAULT_SENSORS
+	}
+
+	for _, sensor := range sensors {
+		if SensorDefs[sensor].ValueType == BOOL_VALUE {
+			return nil, fmt.Errorf("Calibration not supported by sensor: %s", sensor.Name())
+		}
+	}
+
+	result := &Calibration{
+		NodesIds:        nodesIds,
+		ReferenceNodeId: referenceNodeId,
+		Sensors:         sensors,
+		StartedAt:       at,
+	}
+
+	return result, nil
+}
+
+// Returns true if calibration session is still running
+func (calibration *Calibration) Running() bool {
+	return calibration.StoppedAt.IsZero()
+}
+
+// Stop calibration session
+func (calibration *Calibration) Stop(at time.Time) {
+	if calibration.Running() {
+		calibration.StoppedAt = at
+	}
+}
+
+// Returns logged values before shift correction, by sensor, log time and node id
+func (calibration *Calibration) readings(db *Database) map[Sensor]map[int64]map[int]float64 {
+	result := make(map[Sensor]map[int64]map[int]float64)
+	for _, sensor := range calibration.Sensors {
+		result[sensor] = make(map[int64]map[int]float64)
+	}
+
+	for _, nodeId := range calibration.NodesIds {
+		node := db.NodeForId(nodeId)
+		if node == nil {
+			continue
+		}
+
+		for _, nodeLog := range db.nodeLogs(node) {
+			if nodeLog.At.Before(calibration.StartedAt) || (!calibration.Running() && nodeLog.At.After(calibration.StoppedAt)) {
+				continue
+			}
+
+			at := nodeLog.At.Unix()
+
+			for _, sensor := range calibration.Sensors {
+				if !node.sensorEnabled(sensor) {
+					continue
+				}
+
+				value, found := nodeLog.RawValues[sensor]
+				if !found {
+					value, found = nodeLog.Values[sensor]
+				}
+
+				if found {
+					if result[sensor][at] == nil {
+						result[sensor][at] = make(map[int]float64)
+					}
+
+					result[sensor][at][nodeId] = value
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// Compute calibration results
+func (calibration *Calibration) Results(db *Database) []*CalibrationResult {
+	return calibration.computeResults(calibration.readings(db))
+}
+
+// Compute calibration results from given readings
+func (calibration *Calibration) computeResults(readings map[Sensor]map[int64]map[int]float64) []*CalibrationResult {
+	result := make([]*CalibrationResult, 0)
+
+	for _, sensor := range calibration.Sensors {
+		// differences to reference value, by node
+		diffs := make(map[int][]float64)
+
+		for _, values := range readings[sensor] {
+			if len(values) < 2 {
+				// nothing to compare
+				continue
+			}
+
+			reference, found := values[calibration.ReferenceNodeId]
+			if calibration.ReferenceNodeId == 0 {
+				reference, found = median(values), true
+			}
+
+			if !found {
+				continue
+			}
+
+			for nodeId, value := range values {
+				diffs[nodeId] = append(diffs[nodeId], reference-value)
+			}
+		}
+
+		for _, nodeId := range calibration.NodesIds {
+			if len(diffs[nodeId]) == 0 {
+				continue
+			}
+
+			offset := mean(diffs[nodeId])
+			if SensorDefs[sensor].ValueType == INT_VALUE {
+				offset = math.Floor(offset + 0.5)
+			}
+
+			result = append(result, &CalibrationResult{
+				NodeId:    nodeId,
+				Sensor:    sensor.Name(),
+				SamplesNb: len(diffs[nodeId]),
+				Offset:    roundCalibration(offset),
+				Error:     roundCalibration(rmsError(diffs[nodeId], 0)),
+				Residual:  roundCalibration(rmsError(diffs[nodeId], offset)),
+				sensor:    sensor,
+			})
+		}
+	}
+
+	return result
+}
+
+// Apply shift corrections from given results to nodes
+func (calibration *Calibration) Apply(db *Database, results []*CalibrationResult) error {
+	settings := make(map[int]map[Sensor]*SensorSettings)
+
+	for _, calResult := range results {
+		node := db.NodeForId(calResult.NodeId)
+		if node == nil {
+			return fmt.Errorf("Node %d not found", calResult.NodeId)
+		}
+
+		if settings[node.Id] == nil {
+			// keep settings of other sensors
+			settings[node.Id] = make(map[Sensor]*SensorSettings)
+			for sensor, sensorSettings := range node.Settings {
+				settings[node.Id][sensor] = sensorSettings
+			}
+		}
+
+		settings[node.Id][calResult.sensor] = &SensorSettings{Offset: calResult.Offset, Gain: 1}
+	}
+
+	for nodeId, nodeSettings := range settings {
+		node := db.NodeForId(nodeId)
+
+		if err := node.setSensorsSettings(nodeSettings); err != nil {
+			return err
+		}
+
+		db.UpdateNode(node)
+		db.UpdateNodeSettings(node)
+	}
+
+	return nil
+}
+
+// cf. http://stackoverflow.com/a/17323212
+func (calibration *Calibration) toJsonifableMap(results []*CalibrationResult) map[string]interface{} {
+	sensors := make([]string, len(calibration.Sensors))
+	for index, sensor := range calibration.Sensors {
+		sensors[index] = sensor.Name()
+	}
+
+	result := map[string]interface{}{
+		"nodes":      calibration.NodesIds,
+		"sensors":    sensors,
+		"started_at": calibration.StartedAt,
+		"running":    calibration.Running(),
+		"results":    results,
+	}
+
+	if calibration.ReferenceNodeId != 0 {
+		result["reference_node_id"] = calibration.ReferenceNodeId
+	}
+
+	if !calibration.Running() {
+		result["stopped_at"] = calibration.StoppedAt
+	}
+
+	return result
+}
+
+// helper
+func median(values map[int]float64) float64 {
+	sorted := make([]float64, 0)
+	for _, value := range values {
+		sorted = append(sorted, value)
+	}
+
+	sort.Float64s(sorted)
+
+	middle := len(sorted) / 2
+	if (len(sorted) % 2) == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+
+	return sorted[middle]
+}
+
+// helper
+func mean(values []float64) float64 {
+	result := float64(0)
+	for _, value := range values {
+		result += value
+	}
+
+	return result / float64(len(values))
+}
+
+// helper
+func rmsError(diffs []float64, offset float64) float64 {
+	result := float64(0)
+	for _, diff := range diffs {
+		result += (diff - offset) * (diff - offset)
+	}
+
+	return math.Sqrt(result / float64(len(diffs)))
+}
+
+// helper
+func roundCalibration(value float64) float64 {
+	return math.Floor(value*100+0.5) / 100
+}
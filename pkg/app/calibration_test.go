@@ -0,0 +1,110 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewCalibration(t *testing.T) {
+	dbFilename := TempFilename()
+
+	db := newTestDatabase(t, dbFilename)
+	defer destroyTestDatabase(db)
+
+	db.InsertNode(2, TINYTX_TH_NODE)
+	db.InsertNode(3, TINYTX_TH_NODE)
+
+	now := time.Now().UTC()
+
+	_, err := NewCalibration(db, []int{2}, 0, nil, now)
+	assert.NotNil(t, err)
+
+	_, err = NewCalibration(db, []int{2, 5}, 0, nil, now)
+	assert.NotNil(t, err)
+
+	_, err = NewCalibration(db, []int{2, 2}, 0, nil, now)
+	assert.NotNil(t, err)
+
+	_, err = NewCalibration(db, []int{2, 3}, 4, nil, now)
+	assert.NotNil(t, err)
+
+	_, err = NewCalibration(db, []int{2, 3}, 0, []Sensor{MOTION_SENSOR}, now)
+	assert.NotNil(t, err)
+
+	calibration, err := NewCalibration(db, []int{2, 3}, 0, nil, now)
+	assert.Nil(t, err)
+	assert.Equal(t, calibration.Sensors, []Sensor{TEMP_SENSOR, HUMI_SENSOR})
+	assert.True(t, calibration.Running())
+
+	calibration.Stop(now.Add(time.Hour))
+	assert.False(t, calibration.Running())
+}
+
+func Test_Calibration(t *testing.T) {
+	dbFilename := TempFilename()
+
+	db := newTestDatabase(t, dbFilename)
+	defer destroyTestDatabase(db)
+
+	node2 := db.InsertNode(2, TINYTX_TH_NODE)
+	node3 := db.InsertNode(3, TINYTX_TH_NODE)
+	node4 := db.InsertNode(4, TINYTX_TH_NODE)
+
+	// node 2 already has a shift correction, that must be ignored
+	node2.setSensorsSettings(map[Sensor]*SensorSettings{TEMP_SENSOR: {Offset: 3}})
+
+	startedAt := time.Now().UTC().Add(-time.Hour * 24)
+
+	calibration, err := NewCalibration(db, []int{2, 3, 4}, 0, nil, startedAt)
+	assert.Nil(t, err)
+
+	// log before calibration session
+	node2.setSensorRawValue(TEMP_SENSOR, 300)
+	db.insertNodeLog(node2, startedAt.Add(-time.Minute))
+
+	for i := 0; i < 10; i++ {
+		at := startedAt.Add(time.Minute * time.Duration(5*(i+1)))
+
+		node2.setSensorRawValue(TEMP_SENSOR, uint64(210+i))
+		node2.setSensorRawValue(HUMI_SENSOR, 60)
+
+		node3.setSensorRawValue(TEMP_SENSOR, uint64(195+i))
+		node3.setSensorRawValue(HUMI_SENSOR, 55)
+
+		node4.setSensorRawValue(TEMP_SENSOR, uint64(200+i))
+		node4.setSensorRawValue(HUMI_SENSOR, uint64(52+i%2))
+
+		db.insertNodeLog(node2, at)
+		db.insertNodeLog(node3, at)
+		db.insertNodeLog(node4, at)
+	}
+
+	calibration.Stop(time.Now().UTC())
+
+	results := calibration.Results(db)
+	assert.Equal(t, len(results), 6)
+
+	assert.Equal(t, *results[0], CalibrationResult{NodeId: 2, Sensor: "temperature", SamplesNb: 10, Offset: -1, Error: 1, Residual: 0, sensor: TEMP_SENSOR})
+	assert.Equal(t, *results[1], CalibrationResult{NodeId: 3, Sensor: "temperature", SamplesNb: 10, Offset: 0.5, Error: 0.5, Residual: 0, sensor: TEMP_SENSOR})
+	assert.Equal(t, *results[2], CalibrationResult{NodeId: 4, Sensor: "temperature", SamplesNb: 10, Offset: 0, Error: 0, Residual: 0, sensor: TEMP_SENSOR})
+	assert.Equal(t, *results[3], CalibrationResult{NodeId: 2, Sensor: "humidity", SamplesNb: 10, Offset: -5, Error: 5, Residual: 0, sensor: HUMI_SENSOR})
+	assert.Equal(t, *results[5], CalibrationResult{NodeId: 4, Sensor: "humidity", SamplesNb: 10, Offset: 3, Error: 2.55, Residual: 0.71, sensor: HUMI_SENSOR})
+
+	// apply
+	assert.Nil(t, calibration.Apply(db, results))
+
+	assert.Equal(t, node2.Settings, map[Sensor]*SensorSettings{TEMP_SENSOR: {Offset: -1, Gain: 1}, HUMI_SENSOR: {Offset: -5, Gain: 1}})
+	assert.Equal(t, len(node4.Settings), 1)
+	assert.Equal(t, node2.sensorValue(TEMP_SENSOR), float64(20.9))
+	assert.Equal(t, node4.sensorValue(HUMI_SENSOR), int64(56))
+
+	// reference node
+	calibration.ReferenceNodeId = 2
+
+	results = calibration.Results(db)
+	assert.Equal(t, results[1].Offset, float64(1.5))
+	assert.Equal(t, results[2].Offset, float64(1))
+	assert.Equal(t, results[5].Offset, float64(8))
+}
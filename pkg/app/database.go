@@ -3,45 +3,13 @@ package app
 import (
 	"database/sql"
 	"fmt"
+	"sync"
 	"time"
 
 	log "code.google.com/p/log4go"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const NODES_SCHEMA = `
-CREATE TABLE IF NOT EXISTS nodes (
-    id INTEGER NOT NULL PRIMARY KEY,
-    kind INTEGER NOT NULL,
-    updated_at INTEGER,
-    last_seen_at INTEGER,
-    name TEXT,
-    domoticz_idx TEXT,
-    temperature REAL,
-    humidity INTEGER,
-    light INTEGER,
-    motion INTEGER,
-    lowbat INTEGER,
-    vcc INTEGER
-);
-`
-
-const LOGS_SCHEMA = `
-CREATE TABLE IF NOT EXISTS node_logs (
-    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-	node_id INTEGER NOT NULL,
-	at INTEGER NOT NULL,
-	temperature REAL,
-	humidity INTEGER,
-	light INTEGER,
-	motion INTEGER,
-	lowbat INTEGER,
-	vcc INTEGER
-);
-`
-
-var ColNameForSensor map[Sensor]string
-
 // Database
 type Database struct {
 	filePath    string
@@ -49,6 +17,12 @@ type Database struct {
 	queryWriter chan *DatabaseQuery
 	nodes       []*Node
 	sync        bool
+
+	// keeps log and log values queries together, and guards rollup hours
+	logsMutex sync.Mutex
+
+	// starts of hours that received logs not aggregated in rollups yet
+	rollupHours map[int64]bool
 }
 
 // Database Query
@@ -58,38 +32,40 @@ type DatabaseQuery struct {
 	doneChan chan bool
 }
 
-// Init
-func init() {
-	ColNameForSensor = map[Sensor]string{
-		TEMP_SENSOR:   "temperature",
-		HUMI_SENSOR:   "humidity",
-		LIGHT_SENSOR:  "light",
-		MOTION_SENSOR: "motion",
-		LOWBAT_SENSOR: "lowbat",
-		VCC_SENSOR:    "vcc",
+// Open a database connection, without loading nodes
+func OpenDatabase(databasePath string) (*Database, error) {
+	// open
+	sqlDriver, err := sql.Open("sqlite3", databasePath)
+	if err != nil {
+		return nil, err
 	}
+
+	return &Database{filePath: databasePath, driver: sqlDriver, sync: false}, nil
 }
 
 // Setup a new database connection and load nodes
 func LoadDatabase(databasePath string) (*Database, error) {
-	// open
-	sqlDriver, err := sql.Open("sqlite3", databasePath)
+	db, err := OpenDatabase(databasePath)
 	if err != nil {
 		return nil, err
 	}
 
-	db := Database{filePath: databasePath, driver: sqlDriver, sync: false}
-
-	// create tables if necessary
-	db.createTables()
+	// update schema if necessary
+	if _, err := db.Migrate(); err != nil {
+		db.close()
+		return nil, err
+	}
 
 	// load nodes
 	db.loadNodes()
 
+	// aggregate logs inserted since last rollups update
+	db.loadRollupHours()
+
 	// run query writer
 	db.runQueryWriter()
 
-	return &db, nil
+	return db, nil
 }
 
 func (db *Database) SetSync(val bool) {
@@ -140,25 +116,13 @@ func (db *Database) close() {
 	db.driver.Close()
 }
 
-// Create tables
-func (db *Database) createTables() {
-	schemas := [2]string{NODES_SCHEMA, LOGS_SCHEMA}
-
-	for _, schema := range schemas {
-		_, err := db.driver.Exec(schema)
-		if err != nil {
-			panic(log.Critical("Failed to create SQL table %q: %s", err, schema))
-		}
-	}
-}
-
 // Load all nodes
 func (db *Database) loadNodes() {
 	// reset nodes
 	db.nodes = make([]*Node, 0)
 
 	// fetch nodes from db
-	rows, err := db.driver.Query("SELECT * FROM nodes")
+	rows, err := db.driver.Query("SELECT id, kind, updated_at, last_seen_at, name, domoticz_idx, rssi, rssi_min, rssi_avg FROM nodes")
 	if err != nil {
 		panic(log.Critical(err))
 	}
@@ -175,16 +139,13 @@ func (db *Database) loadNodes() {
 			last_seen_at int64
 			name         sql.NullString
 			domoticz_idx sql.NullString
-			temperature  sql.NullFloat64
-			humidity     sql.NullInt64
-			light        sql.NullInt64
-			motion       sql.NullBool
-			lowbat       sql.NullBool
-			vcc          sql.NullInt64
+			rssi         sql.NullInt64
+			rssi_min     sql.NullInt64
+			rssi_avg     sql.NullFloat64
 		)
 
 		// @todo Use github.com/russross/meddler ?
-		rows.Scan(&id, &kind, &updated_at, &last_seen_at, &name, &domoticz_idx, &temperature, &humidity, &light, &motion, &lowbat, &vcc)
+		rows.Scan(&id, &kind, &updated_at, &last_seen_at, &name, &domoticz_idx, &rssi, &rssi_min, &rssi_avg)
 
 		// init node
 		node = &Node{
@@ -192,6 +153,9 @@ func (db *Database) loadNodes() {
 			Kind:       kind,
 			UpdatedAt:  time.Unix(updated_at, 0),
 			LastSeenAt: time.Unix(last_seen_at, 0),
+			Values:     make(map[Sensor]float64),
+			RawValues:  make(map[Sensor]float64),
+			Settings:   make(map[Sensor]*SensorSettings),
 		}
 
 		if name.Valid {
@@ -202,35 +166,116 @@ func (db *Database) loadNodes() {
 			node.DomoticzIdx = domoticz_idx.String
 		}
 
-		if temperature.Valid {
-			node.Temperature = float64(temperature.Float64)
+		if rssi.Valid {
+			node.Rssi = int(rssi.Int64)
 		}
 
-		if humidity.Valid {
-			node.Humidity = uint8(humidity.Int64)
+		if rssi_min.Valid {
+			node.RssiMin = int(rssi_min.Int64)
 		}
 
-		if light.Valid {
-			node.Light = uint8(light.Int64)
+		if rssi_avg.Valid {
+			node.RssiAvg = rssi_avg.Float64
 		}
 
-		if motion.Valid {
-			node.Motion = motion.Bool
-		}
+		// add node to list
+		db.nodes = append(db.nodes, node)
+	}
+
+	rows.Close()
+
+	// fetch sensors values
+	db.loadNodesValues()
+
+	// fetch sensors settings
+	db.loadNodesSettings()
+
+	// fetch previous kinds
+	db.loadNodesPreviousKinds()
+}
+
+// Load sensors values for all nodes
+func (db *Database) loadNodesValues() {
+	rows, err := db.driver.Query("SELECT node_id, sensor, value, raw_value FROM node_values")
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			node_id   int
+			name      string
+			value     sql.NullFloat64
+			raw_value sql.NullFloat64
+		)
+
+		rows.Scan(&node_id, &name, &value, &raw_value)
 
-		if lowbat.Valid {
-			node.LowBattery = lowbat.Bool
+		node := db.NodeForId(node_id)
+		sensor, found := sensorForName(name)
+
+		if (node != nil) && found && value.Valid {
+			node.Values[sensor] = value.Float64
+
+			if raw_value.Valid {
+				node.RawValues[sensor] = raw_value.Float64
+			}
 		}
+	}
+}
+
+// Load sensors settings for all nodes
+func (db *Database) loadNodesSettings() {
+	rows, err := db.driver.Query("SELECT node_id, sensor, offset, gain, disabled FROM node_sensor_settings")
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			node_id  int
+			name     string
+			offset   sql.NullFloat64
+			gain     sql.NullFloat64
+			disabled sql.NullBool
+		)
+
+		rows.Scan(&node_id, &name, &offset, &gain, &disabled)
+
+		node := db.NodeForId(node_id)
+		sensor, found := sensorForName(name)
+
+		if (node != nil) && found {
+			settings := &SensorSettings{Offset: offset.Float64, Gain: gain.Float64, Disabled: disabled.Bool}
+			settings.Gain = settings.gain()
 
-		if vcc.Valid {
-			node.Vcc = uint(vcc.Int64)
+			node.Settings[sensor] = settings
 		}
+	}
+}
 
-		// add node to list
-		db.nodes = append(db.nodes, node)
+// Load previous kind for all nodes
+func (db *Database) loadNodesPreviousKinds() {
+	rows, err := db.driver.Query("SELECT node_id, from_kind FROM node_kind_changes ORDER BY at, id")
+	if err != nil {
+		panic(log.Critical(err))
 	}
+	defer rows.Close()
 
-	rows.Close()
+	for rows.Next() {
+		var (
+			node_id   int
+			from_kind int
+		)
+
+		rows.Scan(&node_id, &from_kind)
+
+		if node := db.NodeForId(node_id); node != nil {
+			node.PreviousKind = from_kind
+		}
+	}
 }
 
 // Get a node
@@ -249,7 +294,14 @@ func (db *Database) InsertNode(id int, kind int) *Node {
 	name := fmt.Sprintf("Node %d", id)
 
 	// init node
-	node := &Node{Id: id, Kind: kind, Name: name}
+	node := &Node{
+		Id:        id,
+		Kind:      kind,
+		Name:      name,
+		Values:    make(map[Sensor]float64),
+		RawValues: make(map[Sensor]float64),
+		Settings:  make(map[Sensor]*SensorSettings),
+	}
 
 	// add node to list
 	db.nodes = append(db.nodes, node)
@@ -271,29 +323,82 @@ func updateNodeQuery(node *Node) *DatabaseQuery {
 	args = append(args, node.LastSeenAt.Unix())
 	args = append(args, node.Name)
 
-	// set sensors values
-	for _, sensor := range node.sensors() {
-		colName := ColNameForSensor[sensor]
-		if colName != "" {
-			value := node.sensorValue(sensor)
+	// set signal strength
+	if node.Rssi != 0 {
+		query += ", rssi = ?, rssi_min = ?, rssi_avg = ?"
+		args = append(args, node.Rssi, node.RssiMin, node.RssiAvg)
+	}
+
+	query += " WHERE id = ?"
+	args = append(args, node.Id)
+
+	return &DatabaseQuery{query: query, args: args}
+}
+
+// Returns queries to replace sensors values of given node
+func updateNodeValuesQueries(node *Node) []*DatabaseQuery {
+	result := []*DatabaseQuery{
+		{
+			query: "DELETE FROM node_values WHERE node_id = ?",
+			args:  []interface{}{node.Id},
+		},
+	}
+
+	if len(node.enabledSensors()) > 0 {
+		args := make([]interface{}, 0)
+
+		query := "INSERT INTO node_values(node_id, sensor, value, raw_value) VALUES"
+		for index, sensor := range node.enabledSensors() {
+			if index > 0 {
+				query += ","
+			}
 
-			query += fmt.Sprintf(", %s = ?", colName)
-			args = append(args, value)
+			query += " (?, ?, ?, ?)"
+			args = append(args, node.Id, sensor.Name(), node.Values[sensor], node.sensorRawValue(sensor))
 		}
+
+		result = append(result, &DatabaseQuery{query: query, args: args})
 	}
 
-	// set NULL for absent sensors
-	for _, sensor := range node.absentSensors() {
-		colName := ColNameForSensor[sensor]
-		if colName != "" {
-			query += fmt.Sprintf(", %s = NULL", colName)
+	return result
+}
+
+// Returns queries to replace sensors settings of given node
+func updateNodeSettingsQueries(node *Node) []*DatabaseQuery {
+	result := []*DatabaseQuery{
+		{
+			query: "DELETE FROM node_sensor_settings WHERE node_id = ?",
+			args:  []interface{}{node.Id},
+		},
+	}
+
+	if len(node.Settings) > 0 {
+		args := make([]interface{}, 0)
+
+		query := "INSERT INTO node_sensor_settings(node_id, sensor, offset, gain, disabled) VALUES"
+		for index, sensor := range settingsSensors(node.Settings) {
+			settings := node.Settings[sensor]
+
+			if index > 0 {
+				query += ","
+			}
+
+			query += " (?, ?, ?, ?, ?)"
+			args = append(args, node.Id, sensor.Name(), settings.Offset, settings.gain(), settings.Disabled)
 		}
+
+		result = append(result, &DatabaseQuery{query: query, args: args})
 	}
 
-	query += " WHERE id = ?"
-	args = append(args, node.Id)
+	return result
+}
 
-	return &DatabaseQuery{query: query, args: args}
+// Update node sensors settings
+func (db *Database) UpdateNodeSettings(node *Node) {
+	// persist in database
+	for _, dbQuery := range updateNodeSettingsQueries(node) {
+		db.writeQuery(dbQuery)
+	}
 }
 
 // Update node
@@ -303,58 +408,94 @@ func (db *Database) UpdateNode(node *Node) {
 
 		// persist in database
 		db.writeQuery(updateNodeQuery(node))
+
+		for _, dbQuery := range updateNodeValuesQueries(node) {
+			db.writeQuery(dbQuery)
+		}
 	}
 }
 
-func insertNodeLogQuery(node *Node) *DatabaseQuery {
+func insertNodeLogQuery(node *Node, at time.Time) *DatabaseQuery {
 	args := make([]interface{}, 0)
 
 	query := "INSERT INTO node_logs(node_id, at"
+	values := "?, ?"
 	args = append(args, node.Id)
-	args = append(args, time.Now().UTC().Unix())
-
-	nbSensors := 0
+	args = append(args, at.Unix())
 
-	for _, sensor := range node.sensors() {
-		colName := ColNameForSensor[sensor]
-		if colName != "" {
-			query += fmt.Sprintf(", %s", colName)
-			args = append(args, node.sensorValue(sensor))
+	if node.Rssi != 0 {
+		query += ", rssi"
+		values += ", ?"
+		args = append(args, node.Rssi)
+	}
 
-			nbSensors += 1
+	if node.Reception != nil {
+		if ratio, ok := node.Reception.Ratio(time.Hour*RECEPTION_LOG_WINDOW, at); ok {
+			query += ", reception"
+			values += ", ?"
+			args = append(args, ratio)
 		}
 	}
 
-	query += ") VALUES(?, ?"
-	for i := 0; i < nbSensors; i++ {
-		query += ", ?"
+	query += ") VALUES(" + values + ")"
+
+	return &DatabaseQuery{query: query, args: args}
+}
+
+// NB: values are attached to the last log inserted for node
+func insertNodeLogValuesQuery(node *Node, at time.Time) *DatabaseQuery {
+	args := make([]interface{}, 0)
+
+	query := "INSERT INTO node_log_values(node_log_id, node_id, at, sensor, value, raw_value) VALUES"
+	for index, sensor := range node.enabledSensors() {
+		if index > 0 {
+			query += ","
+		}
+
+		query += " ((SELECT MAX(id) FROM node_logs WHERE node_id=?), ?, ?, ?, ?, ?)"
+		args = append(args, node.Id, node.Id, at.Unix(), sensor.Name(), node.Values[sensor], node.sensorRawValue(sensor))
 	}
-	query += ")"
 
 	return &DatabaseQuery{query: query, args: args}
 }
 
 // Insert log for given node
-func (db *Database) insertNodeLog(node *Node) {
-	if len(node.sensors()) > 0 {
+func (db *Database) insertNodeLog(node *Node, at time.Time) {
+	if len(node.enabledSensors()) > 0 {
+		// values must not be attached to a log inserted concurrently
+		db.logsMutex.Lock()
+		defer db.logsMutex.Unlock()
+
 		// persist in database
-		db.writeQuery(insertNodeLogQuery(node))
+		db.writeQuery(insertNodeLogQuery(node, at))
+		db.writeQuery(insertNodeLogValuesQuery(node, at))
+
+		db.markRollupHour(at)
 	}
 }
 
 // Insert logs for all nodes
 func (db *Database) insertNodeLogs() {
+	at := time.Now().UTC()
+
 	for _, node := range db.nodes {
-		db.insertNodeLog(node)
+		db.insertNodeLog(node, at)
 	}
 }
 
 // Delete old logs
 func (db *Database) trimNodeLogs(history time.Duration) {
+	limit := time.Now().UTC().Add(-history).Unix()
+
 	// persist in database
 	db.writeQuery(&DatabaseQuery{
 		query: "DELETE FROM node_logs WHERE (at < ?)",
-		args:  []interface{}{time.Now().UTC().Add(-history).Unix()},
+		args:  []interface{}{limit},
+	})
+
+	db.writeQuery(&DatabaseQuery{
+		query: "DELETE FROM node_log_values WHERE (at < ?)",
+		args:  []interface{}{limit},
 	})
 }
 
@@ -362,65 +503,231 @@ func (db *Database) trimNodeLogs(history time.Duration) {
 func (db *Database) nodeLogs(node *Node) []*NodeLog {
 	result := make([]*NodeLog, 0)
 
-	// fetch nodes from db
-	rows, err := db.driver.Query("SELECT * FROM node_logs WHERE node_id=?", node.Id)
+	// fetch logs from db
+	rows, err := db.driver.Query("SELECT id, node_id, at, rssi, reception FROM node_logs WHERE node_id=?", node.Id)
 	if err != nil {
 		panic(log.Critical(err))
 	}
 	defer rows.Close()
 
+	logsById := make(map[int]*NodeLog)
+
 	for rows.Next() {
 		var nodeLog *NodeLog
 
 		// fetch log fields
 		var (
-			id          int
-			node_id     int
-			at          int64
-			temperature sql.NullFloat64
-			humidity    sql.NullInt64
-			light       sql.NullInt64
-			motion      sql.NullBool
-			lowbat      sql.NullBool
-			vcc         sql.NullInt64
+			id        int
+			node_id   int
+			at        int64
+			rssi      sql.NullInt64
+			reception sql.NullFloat64
 		)
 
-		// @todo Use github.com/russross/meddler ?
-		rows.Scan(&id, &node_id, &at, &temperature, &humidity, &light, &motion, &lowbat, &vcc)
+		rows.Scan(&id, &node_id, &at, &rssi, &reception)
 
 		// init log
 		nodeLog = &NodeLog{
-			Id:     id,
-			NodeId: node_id,
-			At:     time.Unix(at, 0),
+			Id:        id,
+			NodeId:    node_id,
+			At:        time.Unix(at, 0),
+			Values:    make(map[Sensor]float64),
+			RawValues: make(map[Sensor]float64),
 		}
 
-		if temperature.Valid {
-			nodeLog.Temperature = float64(temperature.Float64)
+		if rssi.Valid {
+			nodeLog.Rssi = int(rssi.Int64)
 		}
 
-		if humidity.Valid {
-			nodeLog.Humidity = uint8(humidity.Int64)
+		if reception.Valid {
+			nodeLog.Reception = reception.Float64
 		}
 
-		if light.Valid {
-			nodeLog.Light = uint8(light.Int64)
-		}
+		// add log to list
+		result = append(result, nodeLog)
 
-		if motion.Valid {
-			nodeLog.Motion = motion.Bool
-		}
+		logsById[id] = nodeLog
+	}
 
-		if lowbat.Valid {
-			nodeLog.LowBattery = lowbat.Bool
-		}
+	rows.Close()
+
+	// fetch sensors values
+	valueRows, err := db.driver.Query("SELECT node_log_id, sensor, value, raw_value FROM node_log_values WHERE node_id=?", node.Id)
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	defer valueRows.Close()
+
+	for valueRows.Next() {
+		var (
+			node_log_id int
+			name        string
+			value       sql.NullFloat64
+			raw_value   sql.NullFloat64
+		)
 
-		if vcc.Valid {
-			nodeLog.Vcc = uint(vcc.Int64)
+		valueRows.Scan(&node_log_id, &name, &value, &raw_value)
+
+		nodeLog := logsById[node_log_id]
+		sensor, found := sensorForName(name)
+
+		if (nodeLog != nil) && found && value.Valid {
+			nodeLog.Values[sensor] = value.Float64
+
+			if raw_value.Valid {
+				nodeLog.RawValues[sensor] = raw_value.Float64
+			}
 		}
+	}
 
-		// add log to list
-		result = append(result, nodeLog)
+	return result
+}
+
+// Insert a battery replacement
+func (db *Database) insertBatteryReplacement(replacement *BatteryReplacement) {
+	// persist in database
+	db.writeQuery(&DatabaseQuery{
+		query: "INSERT INTO battery_replacements(node_id, at, vcc_before, vcc_after) VALUES(?, ?, ?, ?)",
+		args:  []interface{}{replacement.NodeId, replacement.At.Unix(), replacement.VccBefore, replacement.VccAfter},
+	})
+}
+
+// Fetch battery replacements for given node, oldest first
+func (db *Database) batteryReplacements(node *Node) []*BatteryReplacement {
+	result := make([]*BatteryReplacement, 0)
+
+	rows, err := db.driver.Query("SELECT node_id, at, vcc_before, vcc_after FROM battery_replacements WHERE node_id=? ORDER BY at", node.Id)
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			node_id    int
+			at         int64
+			vcc_before sql.NullInt64
+			vcc_after  sql.NullInt64
+		)
+
+		rows.Scan(&node_id, &at, &vcc_before, &vcc_after)
+
+		result = append(result, &BatteryReplacement{
+			NodeId:    node_id,
+			At:        time.Unix(at, 0).UTC(),
+			VccBefore: int(vcc_before.Int64),
+			VccAfter:  int(vcc_after.Int64),
+		})
+	}
+
+	return result
+}
+
+// Insert a node kind change, and persist new node kind
+func (db *Database) insertNodeKindChange(change *NodeKindChange) {
+	// persist in database
+	db.writeQuery(&DatabaseQuery{
+		query: "INSERT INTO node_kind_changes(node_id, at, from_kind, to_kind) VALUES(?, ?, ?, ?)",
+		args:  []interface{}{change.NodeId, change.At.Unix(), change.FromKind, change.ToKind},
+	})
+
+	db.writeQuery(&DatabaseQuery{
+		query: "UPDATE nodes SET kind = ? WHERE id = ?",
+		args:  []interface{}{change.ToKind, change.NodeId},
+	})
+}
+
+// Fetch kind changes for given node, oldest first
+func (db *Database) nodeKindChanges(node *Node) []*NodeKindChange {
+	result := make([]*NodeKindChange, 0)
+
+	rows, err := db.driver.Query("SELECT node_id, at, from_kind, to_kind FROM node_kind_changes WHERE node_id=? ORDER BY at, id", node.Id)
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			node_id   int
+			at        int64
+			from_kind int
+			to_kind   int
+		)
+
+		rows.Scan(&node_id, &at, &from_kind, &to_kind)
+
+		result = append(result, &NodeKindChange{
+			NodeId:   node_id,
+			At:       time.Unix(at, 0).UTC(),
+			FromKind: from_kind,
+			ToKind:   to_kind,
+		})
+	}
+
+	return result
+}
+
+// Insert a discrete sensor event
+func (db *Database) insertSensorEvent(event *SensorEvent) {
+	// persist in database
+	db.writeQuery(&DatabaseQuery{
+		query: "INSERT INTO node_sensor_events(node_id, sensor, at, value) VALUES(?, ?, ?, ?)",
+		args:  []interface{}{event.NodeId, event.Sensor.Name(), event.At.Unix(), event.Value},
+	})
+}
+
+// Delete old sensor events
+func (db *Database) trimSensorEvents(history time.Duration) {
+	limit := time.Now().UTC().Add(-history).Unix()
+
+	// persist in database
+	db.writeQuery(&DatabaseQuery{
+		query: "DELETE FROM node_sensor_events WHERE (at < ?)",
+		args:  []interface{}{limit},
+	})
+}
+
+// Fetch sensor events for given node between given times, oldest first
+//
+// All discrete sensors events are returned if sensor name is empty.
+func (db *Database) nodeSensorEvents(node *Node, sensorName string, from time.Time, to time.Time) []*SensorEvent {
+	result := make([]*SensorEvent, 0)
+
+	query := "SELECT node_id, sensor, at, value FROM node_sensor_events WHERE node_id=? AND at >= ? AND at <= ?"
+	args := []interface{}{node.Id, from.Unix(), to.Unix()}
+
+	if sensorName != "" {
+		query += " AND sensor=?"
+		args = append(args, sensorName)
+	}
+
+	query += " ORDER BY at, id"
+
+	rows, err := db.driver.Query(query, args...)
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			node_id int
+			name    string
+			at      int64
+			value   sql.NullFloat64
+		)
+
+		rows.Scan(&node_id, &name, &at, &value)
+
+		if sensor, found := sensorForName(name); found {
+			result = append(result, &SensorEvent{
+				NodeId: node_id,
+				Sensor: sensor,
+				At:     time.Unix(at, 0).UTC(),
+				Value:  value.Float64,
+			})
+		}
 	}
 
 	return result
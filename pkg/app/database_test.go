@@ -57,25 +57,29 @@ func Test_InsertNode(t *testing.T) {
 
 func Test_UpdateNodeQuery(t *testing.T) {
 	node := &Node{
-		Id:          2,
-		Kind:        JEENODE_THLM_NODE,
-		UpdatedAt:   time.Now().UTC(),
-		LastSeenAt:  time.Now().UTC(),
-		Name:        "test",
-		Temperature: float64(21.3),
-		Humidity:    uint8(74),
-		Light:       uint8(61),
-		Motion:      true,
-		LowBattery:  false,
+		Id:         2,
+		Kind:       JEENODE_THLM_NODE,
+		UpdatedAt:  time.Now().UTC(),
+		LastSeenAt: time.Now().UTC(),
+		Name:       "test",
+		Values:     map[Sensor]float64{TEMP_SENSOR: 21.3, HUMI_SENSOR: 74, LIGHT_SENSOR: 61, MOTION_SENSOR: 1, LOWBAT_SENSOR: 0},
+		RawValues:  map[Sensor]float64{TEMP_SENSOR: 22.1},
 	}
 
-	expected_query := "UPDATE nodes SET updated_at = ?, last_seen_at = ?, name = ?, temperature = ?, humidity = ?, light = ?, motion = ?, lowbat = ?, vcc = NULL WHERE id = ?"
-	expected_args := []interface{}{node.UpdatedAt.Unix(), node.LastSeenAt.Unix(), node.Name, float64(21.3), uint8(74), uint8(61), true, false, node.Id}
+	expected_query := "UPDATE nodes SET updated_at = ?, last_seen_at = ?, name = ? WHERE id = ?"
+	expected_args := []interface{}{node.UpdatedAt.Unix(), node.LastSeenAt.Unix(), node.Name, node.Id}
 
 	dbQuery := updateNodeQuery(node)
 
 	assert.Equal(t, dbQuery.query, expected_query)
 	assert.True(t, reflect.DeepEqual(dbQuery.args, expected_args))
+
+	dbQueries := updateNodeValuesQueries(node)
+	assert.Equal(t, len(dbQueries), 2)
+
+	assert.Equal(t, dbQueries[0].query, "DELETE FROM node_values WHERE node_id = ?")
+	assert.Equal(t, dbQueries[1].query, "INSERT INTO node_values(node_id, sensor, value, raw_value) VALUES (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?)")
+	assert.Equal(t, dbQueries[1].args[:8], []interface{}{2, "temperature", 21.3, 22.1, 2, "humidity", float64(74), float64(74)})
 }
 
 func Test_UpdateNode(t *testing.T) {
@@ -89,16 +93,17 @@ func Test_UpdateNode(t *testing.T) {
 	node2 := db.InsertNode(2, JEENODE_THLM_NODE)
 	node3 := db.InsertNode(3, TINYTX_TH_NODE)
 
-	node2.Temperature = float64(21.3)
-	node2.Humidity = uint8(74)
-	node2.Light = uint8(61)
-	node2.Motion = true
-	node2.LowBattery = false
+	node2.setSensorValue(TEMP_SENSOR, 21.3)
+	node2.setSensorValue(HUMI_SENSOR, 74)
+	node2.setSensorValue(LIGHT_SENSOR, 61)
+	node2.setSensorValue(MOTION_SENSOR, 1)
+	node2.setSensorValue(LOWBAT_SENSOR, 0)
 
 	db.UpdateNode(node2)
 
-	node3.Temperature = float64(19.4)
-	node3.Vcc = 3096
+	node3.setSensorValue(TEMP_SENSOR, 19.4)
+	node3.setSensorValue(VCC_SENSOR, 3096)
+	node3.recordRssi(-72)
 
 	db.UpdateNode(node3)
 
@@ -110,15 +115,19 @@ func Test_UpdateNode(t *testing.T) {
 	assert.Equal(t, len(db2.nodes), 2)
 
 	node2 = db2.NodeForId(2)
-	assert.Equal(t, node2.Temperature, float64(21.3))
-	assert.Equal(t, node2.Humidity, uint8(74))
-	assert.Equal(t, node2.Light, uint8(61))
-	assert.Equal(t, node2.Motion, true)
-	assert.Equal(t, node2.LowBattery, false)
+	assert.Equal(t, node2.sensorValue(TEMP_SENSOR), float64(21.3))
+	assert.Equal(t, node2.sensorValue(HUMI_SENSOR), int64(74))
+	assert.Equal(t, node2.sensorValue(LIGHT_SENSOR), int64(61))
+	assert.Equal(t, node2.sensorValue(MOTION_SENSOR), true)
+	assert.Equal(t, node2.sensorValue(LOWBAT_SENSOR), false)
 
 	node3 = db2.NodeForId(3)
-	assert.Equal(t, node3.Temperature, float64(19.4))
-	assert.Equal(t, node3.Vcc, uint(3096))
+	assert.Equal(t, node3.sensorValue(TEMP_SENSOR), float64(19.4))
+	assert.Equal(t, node3.sensorValue(VCC_SENSOR), int64(3096))
+	assert.Equal(t, len(node3.Values), 3)
+	assert.Equal(t, node3.Rssi, -72)
+	assert.Equal(t, node3.RssiMin, -72)
+	assert.Equal(t, node3.RssiAvg, float64(-72))
 }
 
 func Test_InsertNodeLogs(t *testing.T) {
@@ -132,14 +141,15 @@ func Test_InsertNodeLogs(t *testing.T) {
 	node2 := db.InsertNode(2, JEENODE_THLM_NODE)
 	node3 := db.InsertNode(3, TINYTX_TH_NODE)
 
-	node2.Temperature = float64(21.3)
-	node2.Humidity = uint8(74)
-	node2.Light = uint8(61)
-	node2.Motion = true
-	node2.LowBattery = false
+	node2.setSensorValue(TEMP_SENSOR, 21.3)
+	node2.setSensorValue(HUMI_SENSOR, 74)
+	node2.setSensorValue(LIGHT_SENSOR, 61)
+	node2.setSensorValue(MOTION_SENSOR, 1)
+	node2.setSensorValue(LOWBAT_SENSOR, 0)
 
-	node3.Temperature = float64(19.4)
-	node3.Vcc = 3096
+	node3.setSensorValue(TEMP_SENSOR, 19.4)
+	node3.setSensorValue(VCC_SENSOR, 3096)
+	node3.recordRssi(-72)
 
 	db.insertNodeLogs()
 
@@ -152,16 +162,81 @@ func Test_InsertNodeLogs(t *testing.T) {
 	assert.Equal(t, len(nodeLogs), 1)
 
 	nodeLog := nodeLogs[0]
-	assert.Equal(t, nodeLog.Temperature, float64(21.3))
-	assert.Equal(t, nodeLog.Humidity, uint8(74))
-	assert.Equal(t, nodeLog.Light, uint8(61))
-	assert.Equal(t, nodeLog.Motion, true)
-	assert.Equal(t, nodeLog.LowBattery, false)
+	assert.Equal(t, nodeLog.Values, map[Sensor]float64{TEMP_SENSOR: 21.3, HUMI_SENSOR: 74, LIGHT_SENSOR: 61, MOTION_SENSOR: 1, LOWBAT_SENSOR: 0})
 
 	nodeLogs = db2.nodeLogs(node3)
 	assert.Equal(t, len(nodeLogs), 1)
 
 	nodeLog = nodeLogs[0]
-	assert.Equal(t, nodeLog.Temperature, float64(19.4))
-	assert.Equal(t, nodeLog.Vcc, 3096)
+	assert.Equal(t, nodeLog.Values, map[Sensor]float64{TEMP_SENSOR: 19.4, HUMI_SENSOR: 0, VCC_SENSOR: 3096})
+	assert.Equal(t, nodeLog.Rssi, -72)
+}
+
+func Test_InsertNodeLogsSameSecond(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	node := db.InsertNode(3, TINYTX_TH_NODE)
+	at := time.Now().UTC()
+
+	node.setSensorValue(TEMP_SENSOR, 19.4)
+	db.insertNodeLog(node, at)
+
+	node.setSensorValue(TEMP_SENSOR, 19.6)
+	db.insertNodeLog(node, at)
+
+	nodeLogs := db.nodeLogs(node)
+	assert.Equal(t, len(nodeLogs), 2)
+	assert.Equal(t, nodeLogs[0].Values[TEMP_SENSOR], 19.4)
+	assert.Equal(t, nodeLogs[1].Values[TEMP_SENSOR], 19.6)
+}
+
+func Test_UpdateNodeSettings(t *testing.T) {
+	dbFilename := TempFilename()
+
+	db := newTestDatabase(t, dbFilename)
+	defer destroyTestDatabase(db)
+
+	node := db.InsertNode(4, TINYTX_TH_NODE)
+
+	err := node.setSensorsSettings(map[Sensor]*SensorSettings{TEMP_SENSOR: {Offset: -1.5}, HUMI_SENSOR: {Offset: 8, Gain: 0.9}, VCC_SENSOR: {Disabled: true}})
+	assert.Nil(t, err)
+
+	node.setSensorRawValue(TEMP_SENSOR, 213)
+	node.setSensorRawValue(HUMI_SENSOR, 60)
+
+	db.UpdateNode(node)
+	db.UpdateNodeSettings(node)
+	db.insertNodeLogs()
+
+	// reopen database
+	db.close()
+	db2 := newTestDatabase(t, dbFilename)
+	defer destroyTestDatabase(db2)
+
+	node = db2.NodeForId(4)
+	assert.Equal(t, node.Settings, map[Sensor]*SensorSettings{TEMP_SENSOR: {Offset: -1.5, Gain: 1}, HUMI_SENSOR: {Offset: 8, Gain: 0.9}, VCC_SENSOR: {Gain: 1, Disabled: true}})
+	assert.Equal(t, len(node.Values), 2)
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), float64(19.8))
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(62))
+	assert.Equal(t, node.sensorRawValue(TEMP_SENSOR), float64(21.3))
+	assert.Equal(t, node.sensorRawValue(HUMI_SENSOR), float64(60))
+
+	nodeLogs := db2.nodeLogs(node)
+	assert.Equal(t, len(nodeLogs), 1)
+	assert.Equal(t, nodeLogs[0].Values[TEMP_SENSOR], float64(19.8))
+	assert.Equal(t, nodeLogs[0].RawValues[TEMP_SENSOR], float64(21.3))
+
+	// reset settings
+	err = node.setSensorsSettings(map[Sensor]*SensorSettings{})
+	assert.Nil(t, err)
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), float64(21.3))
+
+	db2.UpdateNodeSettings(node)
+
+	db2.close()
+	db3 := newTestDatabase(t, dbFilename)
+	defer destroyTestDatabase(db3)
+
+	assert.Equal(t, len(db3.NodeForId(4).Settings), 0)
 }
@@ -0,0 +1,40 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// Detects the same packet received by several gateways
+type Deduplicator struct {
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+// Instanciate a new deduplicator with given window
+func NewDeduplicator(window time.Duration) *Deduplicator {
+	return &Deduplicator{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Returns true if the same packet was already received during deduplication window
+func (dedup *Deduplicator) isDuplicate(dataLog *Rf12demoDataLog) bool {
+	// forget old packets
+	for key, at := range dedup.seen {
+		if dataLog.at.Sub(at) > dedup.window {
+			delete(dedup.seen, key)
+		}
+	}
+
+	key := fmt.Sprintf("%d %d %v", dataLog.nodeId, dataLog.nodeKind, dataLog.data)
+
+	if _, found := dedup.seen[key]; found {
+		return true
+	}
+
+	dedup.seen[key] = dataLog.at
+
+	return false
+}
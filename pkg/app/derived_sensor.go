@@ -0,0 +1,144 @@
+package app
+
+import (
+	"math"
+
+	log "code.google.com/p/log4go"
+)
+
+// Derived sensor definition: its value is computed from other sensors values of the same node
+type DerivedSensorDef struct {
+	Sensor Sensor
+	Inputs []Sensor
+
+	// returns false if value can't be computed
+	Compute func(values map[Sensor]float64) (float64, bool)
+}
+
+// registered derived sensors
+var DerivedSensorDefs = []*DerivedSensorDef{
+	{Sensor: DEW_POINT_SENSOR, Inputs: []Sensor{TEMP_SENSOR, HUMI_SENSOR}, Compute: computeDewPoint},
+	{Sensor: ABS_HUMI_SENSOR, Inputs: []Sensor{TEMP_SENSOR, HUMI_SENSOR}, Compute: computeAbsoluteHumidity},
+	{Sensor: HEAT_INDEX_SENSOR, Inputs: []Sensor{TEMP_SENSOR, HUMI_SENSOR}, Compute: computeHeatIndex},
+}
+
+// Register a new derived sensor
+//
+// If derived sensor is already registered for the same inputs, its formula is replaced.
+func RegisterDerivedSensor(sensor Sensor, inputs []Sensor, compute func(values map[Sensor]float64) (float64, bool)) {
+	if SensorDefs[sensor] == nil {
+		panic(log.Critical("Sensor %d not registered", sensor))
+	}
+
+	def := &DerivedSensorDef{Sensor: sensor, Inputs: inputs, Compute: compute}
+
+	for index, other := range DerivedSensorDefs {
+		if (other.Sensor == sensor) && sameSensors(other.Inputs, inputs) {
+			DerivedSensorDefs[index] = def
+			return
+		}
+	}
+
+	DerivedSensorDefs = append(DerivedSensorDefs, def)
+}
+
+// Returns derived sensor definition, or nil if given sensor is not derived
+func derivedSensorDef(sensor Sensor, sensors []Sensor) *DerivedSensorDef {
+	for _, def := range DerivedSensorDefs {
+		if (def.Sensor == sensor) && includesSensors(sensors, def.Inputs) {
+			return def
+		}
+	}
+
+	return nil
+}
+
+// Returns derived sensors that can be computed from given sensors
+func derivedSensors(sensors []Sensor) []Sensor {
+	result := make([]Sensor, 0)
+
+	for _, def := range DerivedSensorDefs {
+		if includesSensors(sensors, def.Inputs) && !includesSensors(sensors, []Sensor{def.Sensor}) && !includesSensors(result, []Sensor{def.Sensor}) {
+			result = append(result, def.Sensor)
+		}
+	}
+
+	return result
+}
+
+// helper
+func includesSensors(sensors []Sensor, included []Sensor) bool {
+	for _, sensor := range included {
+		found := false
+		for _, other := range sensors {
+			if other == sensor {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// helper
+func sameSensors(a []Sensor, b []Sensor) bool {
+	return (len(a) == len(b)) && includesSensors(a, b)
+}
+
+// Dew point in °C (Magnus formula)
+func computeDewPoint(values map[Sensor]float64) (float64, bool) {
+	temp, humi := values[TEMP_SENSOR], values[HUMI_SENSOR]
+	if humi <= 0 {
+		return 0, false
+	}
+
+	a, b := 17.62, 243.12
+
+	gamma := math.Log(humi/100) + a*temp/(b+temp)
+
+	return roundDerived(b * gamma / (a - gamma)), true
+}
+
+// Absolute humidity in g/m3
+func computeAbsoluteHumidity(values map[Sensor]float64) (float64, bool) {
+	temp, humi := values[TEMP_SENSOR], values[HUMI_SENSOR]
+
+	// saturation vapour pressure in hPa
+	pressure := 6.112 * math.Exp(17.67*temp/(temp+243.5))
+
+	return roundDerived(pressure * humi * 2.1674 / (273.15 + temp)), true
+}
+
+// Heat index in °C (NOAA formula)
+func computeHeatIndex(values map[Sensor]float64) (float64, bool) {
+	temp, humi := values[TEMP_SENSOR], values[HUMI_SENSOR]
+
+	// computed in °F
+	f := temp*1.8 + 32
+
+	result := 0.5 * (f + 61 + (f-68)*1.2 + humi*0.094)
+
+	if (result+f)/2 >= 80 {
+		result = -42.379 + 2.04901523*f + 10.14333127*humi - 0.22475541*f*humi -
+			0.00683783*f*f - 0.05481717*humi*humi + 0.00122874*f*f*humi +
+			0.00085282*f*humi*humi - 0.00000199*f*f*humi*humi
+
+		if (humi < 13) && (f >= 80) && (f <= 112) {
+			result -= ((13 - humi) / 4) * math.Sqrt((17-math.Abs(f-95))/17)
+		} else if (humi > 85) && (f >= 80) && (f <= 87) {
+			result += ((humi - 85) / 10) * ((87 - f) / 5)
+		}
+	}
+
+	return roundDerived((result - 32) / 1.8), true
+}
+
+// helper
+func roundDerived(value float64) float64 {
+	return math.Floor(value*10+0.5) / 10
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_DerivedSensorsFormulas(t *testing.T) {
+	values := map[Sensor]float64{TEMP_SENSOR: 21.3, HUMI_SENSOR: 74}
+
+	value, ok := computeDewPoint(values)
+	assert.True(t, ok)
+	assert.Equal(t, value, float64(16.5))
+
+	value, ok = computeAbsoluteHumidity(values)
+	assert.True(t, ok)
+	assert.Equal(t, value, float64(13.8))
+
+	value, ok = computeHeatIndex(values)
+	assert.True(t, ok)
+	assert.Equal(t, value, float64(21.4))
+
+	// cellar
+	values = map[Sensor]float64{TEMP_SENSOR: 12, HUMI_SENSOR: 85}
+
+	value, _ = computeDewPoint(values)
+	assert.Equal(t, value, float64(9.6))
+
+	value, _ = computeAbsoluteHumidity(values)
+	assert.Equal(t, value, float64(9.1))
+
+	// hot and humid
+	values = map[Sensor]float64{TEMP_SENSOR: 32, HUMI_SENSOR: 70}
+
+	value, _ = computeHeatIndex(values)
+	assert.Equal(t, value, float64(40.4))
+
+	// no humidity
+	_, ok = computeDewPoint(map[Sensor]float64{TEMP_SENSOR: 21.3, HUMI_SENSOR: 0})
+	assert.False(t, ok)
+}
+
+func Test_DerivedSensors(t *testing.T) {
+	node := &Node{Id: 4, Kind: TINYTX_TH_NODE}
+
+	assert.Equal(t, node.sensors(), []Sensor{TEMP_SENSOR, HUMI_SENSOR, VCC_SENSOR})
+	assert.Equal(t, node.allSensors(), []Sensor{TEMP_SENSOR, HUMI_SENSOR, VCC_SENSOR, DEW_POINT_SENSOR, ABS_HUMI_SENSOR, HEAT_INDEX_SENSOR})
+	assert.True(t, node.haveSensor(DEW_POINT_SENSOR))
+
+	assert.Nil(t, node.HandleData(node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 213, HUMI_SENSOR: 74, VCC_SENSOR: 3000})))
+	assert.Equal(t, node.sensorValue(DEW_POINT_SENSOR), float64(16.5))
+	assert.Equal(t, node.sensorValue(ABS_HUMI_SENSOR), float64(13.8))
+	assert.Equal(t, node.sensorValue(HEAT_INDEX_SENSOR), float64(21.4))
+	assert.Equal(t, node.toJsonifableMap()["dew_point"], float64(16.5))
+
+	assert.Equal(t, node.DomoticzDevicesParams("1"), []string{
+		"hid=1&did=2004&dunit=1&dtype=82&dsubtype=1&nvalue=0&svalue=21.3;74;0",
+		"hid=1&did=2004&dunit=6&dtype=80&dsubtype=1&nvalue=0&svalue=16.5",
+		"hid=1&did=2004&dunit=8&dtype=80&dsubtype=1&nvalue=0&svalue=21.4",
+		"hid=1&did=2004&dunit=7&dtype=243&dsubtype=31&nvalue=0&svalue=13.8",
+	})
+
+	// derived from corrected values
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{HUMI_SENSOR: {Offset: -4}}))
+	assert.Equal(t, node.sensorValue(DEW_POINT_SENSOR), float64(15.6))
+
+	// disabled input
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{HUMI_SENSOR: {Disabled: true}}))
+	assert.Equal(t, node.enabledSensors(), []Sensor{TEMP_SENSOR, VCC_SENSOR})
+
+	// disabled derived sensor
+	node = &Node{Id: 4, Kind: TINYTX_TH_NODE}
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{HEAT_INDEX_SENSOR: {Disabled: true}}))
+	assert.Nil(t, node.HandleData(node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 213, HUMI_SENSOR: 74, VCC_SENSOR: 3000})))
+	assert.Equal(t, node.enabledSensors(), []Sensor{TEMP_SENSOR, HUMI_SENSOR, VCC_SENSOR, DEW_POINT_SENSOR, ABS_HUMI_SENSOR})
+
+	// no derived sensor
+	node = &Node{Id: 3, Kind: TINYTX_T_NODE}
+	assert.Equal(t, node.allSensors(), []Sensor{TEMP_SENSOR, VCC_SENSOR})
+}
+
+func Test_RegisterDerivedSensor(t *testing.T) {
+	saved := DerivedSensorDefs
+	defer func() { DerivedSensorDefs = saved }()
+
+	DerivedSensorDefs = append([]*DerivedSensorDef{}, saved...)
+
+	// replace formula
+	RegisterDerivedSensor(DEW_POINT_SENSOR, []Sensor{HUMI_SENSOR, TEMP_SENSOR}, func(values map[Sensor]float64) (float64, bool) {
+		return values[TEMP_SENSOR] - (100-values[HUMI_SENSOR])/5, true
+	})
+	assert.Equal(t, len(DerivedSensorDefs), len(saved))
+
+	// new sensors combination
+	RegisterDerivedSensor(DEW_POINT_SENSOR, []Sensor{TEMP_SENSOR, CO2_SENSOR}, func(values map[Sensor]float64) (float64, bool) {
+		return 0, false
+	})
+	assert.Equal(t, len(DerivedSensorDefs), len(saved)+1)
+
+	node := &Node{Id: 4, Kind: TINYTX_TH_NODE}
+	assert.Nil(t, node.HandleData(node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 213, HUMI_SENSOR: 74, VCC_SENSOR: 3000})))
+	assert.Equal(t, node.sensorValue(DEW_POINT_SENSOR), float64(16.1))
+}
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// size of subscribers channels
+const EVENT_BUS_BUFFER = 16
+
+// events kinds
+const (
+	BATTERY_LOW_EVENT      = "battery_low"
+	BATTERY_OK_EVENT       = "battery_ok"
+	BATTERY_REPLACED_EVENT = "battery_replaced"
+)
+
+// Event about a node
+type Event struct {
+	Kind   string                 `json:"kind"`
+	NodeId int                    `json:"node_id"`
+	At     time.Time              `json:"at"`
+	Data   map[string]interface{} `json:"data,omitempty"`
+}
+
+// Events dispatcher
+type EventBus struct {
+	subscribers map[string][]chan *Event
+
+	mutex sync.RWMutex
+}
+
+// Instanciate a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[string][]chan *Event),
+	}
+}
+
+// Returns a channel that receives published events of given kinds, or all events if no kind is given
+func (bus *EventBus) Subscribe(kinds ...string) chan *Event {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+
+	result := make(chan *Event, EVENT_BUS_BUFFER)
+
+	if len(kinds) == 0 {
+		kinds = []string{""}
+	}
+
+	for _, kind := range kinds {
+		bus.subscribers[kind] = append(bus.subscribers[kind], result)
+	}
+
+	return result
+}
+
+// Send event to subscribers
+//
+// A subscriber that does not consume its events fast enough misses them.
+func (bus *EventBus) Publish(event *Event) {
+	bus.mutex.RLock()
+	defer bus.mutex.RUnlock()
+
+	for _, kind := range []string{event.Kind, ""} {
+		for _, subscriber := range bus.subscribers[kind] {
+			select {
+			case subscriber <- event:
+			default:
+				log.Warn("Event dropped, subscriber is too slow: %s", event.Kind)
+			}
+		}
+	}
+}
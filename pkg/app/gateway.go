@@ -0,0 +1,381 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+
+	"github.com/aymerick/jeego/pkg/config"
+	"github.com/aymerick/jeego/pkg/serial_reader"
+)
+
+const (
+	GATEWAY_CONFIG_TIMEOUT = 5 // in seconds
+)
+
+// valid RF12demo command, eg: 8b, 212g, 1,2,3,4s
+var gatewayCommandRegexp = regexp.MustCompile(`^[0-9,]*[a-zA-Z]$`)
+
+// RF12demo gateway (eg: a JeeLink plugged on serial port)
+type Gateway struct {
+	Name   string
+	Reader *serial_reader.SerialReader
+
+	// RF12demo sketch configuration to send at startup
+	rf12demoConf *config.Rf12demoConfig
+
+	// commands to send to gateway
+	commandChan chan *GatewayCommand
+
+	// nodes heard by gateway
+	nodesStats map[int]*GatewayNodeStats
+
+	// received packets statistics
+	radioStats RadioStats
+
+	// rejected packets, by parse error kind
+	parseErrors map[ParseErrorKind]int
+
+	// RF12demo sketch status
+	status         Rf12demoStatus
+	statusAt       time.Time
+	configVerified bool
+
+	mutex sync.RWMutex
+}
+
+// Command to send to gateway
+type GatewayCommand struct {
+	text     string
+	doneChan chan error
+}
+
+// Statistics about a node heard by a gateway
+type GatewayNodeStats struct {
+	NodeId     int       `json:"node_id"`
+	FramesNb   int       `json:"frames_nb"`
+	FirstNb    int       `json:"first_nb"` // number of frames received by that gateway before any other
+	LastSeenAt time.Time `json:"last_seen_at"`
+
+	// signal strength of frames received by that gateway, duplicates included
+	Rssi    int     `json:"rssi"`
+	RssiMin int     `json:"rssi_min"`
+	RssiAvg float64 `json:"rssi_avg"`
+}
+
+// Instanciate a new gateway
+func NewGateway(name string, transport serial_reader.Transport, rf12demoConf *config.Rf12demoConfig) *Gateway {
+	result := &Gateway{
+		Name:         name,
+		Reader:       serial_reader.NewWithTransport(transport),
+		rf12demoConf: rf12demoConf,
+		commandChan:  make(chan *GatewayCommand),
+		nodesStats:   make(map[int]*GatewayNodeStats),
+		parseErrors:  make(map[ParseErrorKind]int),
+	}
+
+	// configure RF12demo sketch each time gateway is connected
+	result.Reader.OnConnect = result.configure
+
+	return result
+}
+
+// Start reading lines from gateway and send them to given channel
+func (gateway *Gateway) Run(outputChan chan *Rf12demoLine) {
+	linesChan := make(chan string)
+
+	go gateway.runCommandWriter()
+
+	go gateway.Reader.Run(linesChan)
+
+	go func() {
+		// loop forever
+		for {
+			text := <-linesChan
+
+			// tag line with gateway
+			outputChan <- &Rf12demoLine{gateway: gateway, text: text, at: time.Now().UTC()}
+		}
+	}()
+}
+
+// Send commands to gateway, one at a time
+func (gateway *Gateway) runCommandWriter() {
+	// loop forever
+	for cmd := range gateway.commandChan {
+		cmd.doneChan <- gateway.Reader.WriteCommand(cmd.text)
+	}
+}
+
+// Send a raw command to RF12demo sketch, and wait for it to be written
+func (gateway *Gateway) SendCommand(text string) error {
+	if !gatewayCommandRegexp.MatchString(text) {
+		return fmt.Errorf("Invalid RF12demo command: %q", text)
+	}
+
+	cmd := &GatewayCommand{text: text, doneChan: make(chan error)}
+
+	gateway.commandChan <- cmd
+
+	return <-cmd.doneChan
+}
+
+// Send a packet to given node
+//
+// Example: sending bytes 1, 2 and 3 to node 5 gives the RF12demo command: 1,2,3,5s
+func (gateway *Gateway) SendPacket(nodeId int, data []byte) error {
+	cmd := ""
+	for _, b := range data {
+		cmd += fmt.Sprintf("%d,", b)
+	}
+
+	return gateway.SendCommand(fmt.Sprintf("%s%ds", cmd, nodeId))
+}
+
+// Returns true if RF12demo sketch is configured in collect mode, ie. ACK requests are not answered
+func (gateway *Gateway) collectMode() bool {
+	return (gateway.rf12demoConf != nil) && gateway.rf12demoConf.Collect
+}
+
+// Send configuration to RF12demo sketch, and check that it is applied
+func (gateway *Gateway) configure() {
+	conf := gateway.rf12demoConf
+	if conf == nil {
+		return
+	}
+
+	gateway.mutex.Lock()
+	gateway.configVerified = false
+	gateway.mutex.Unlock()
+
+	sentAt := time.Now().UTC()
+
+	for _, cmd := range conf.Commands() {
+		if err := gateway.SendCommand(cmd); err != nil {
+			log.Error("Gateway %s: failed to send command %s: %s", gateway.Name, cmd, err)
+			return
+		}
+	}
+
+	// wait for configuration reply
+	deadline := sentAt.Add(time.Second * GATEWAY_CONFIG_TIMEOUT)
+	for time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 100)
+
+		if gateway.checkConfig(sentAt) {
+			log.Info("Gateway %s: RF12demo configured", gateway.Name)
+			return
+		}
+	}
+
+	status, _ := gateway.Status()
+	log.Error("Gateway %s: RF12demo configuration not confirmed, expected: %+v / reported: %+v", gateway.Name, *conf, status)
+}
+
+// Returns true if RF12demo sketch reported expected configuration after given time
+func (gateway *Gateway) checkConfig(since time.Time) bool {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	conf := gateway.rf12demoConf
+	status := gateway.status
+
+	if !status.Configured || gateway.statusAt.Before(since) {
+		return false
+	}
+
+	if ((conf.Band != 0) && (conf.BandMHz() != status.Band)) ||
+		((conf.Group != 0) && (conf.Group != status.Group)) ||
+		((conf.NodeId != 0) && (conf.NodeId != status.NodeId)) ||
+		(conf.Quiet != status.Quiet) || (conf.Collect != status.Collect) {
+		return false
+	}
+
+	gateway.configVerified = true
+
+	return true
+}
+
+// Record status reported by RF12demo sketch
+func (gateway *Gateway) statusReceived(status *Rf12demoStatus, at time.Time) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	if status.Version != 0 {
+		gateway.status.Version = status.Version
+	}
+
+	if status.Configured {
+		version := gateway.status.Version
+
+		gateway.status = *status
+		gateway.status.Version = version
+	}
+
+	gateway.statusAt = at
+}
+
+// Returns RF12demo sketch status, and true if configuration was verified
+func (gateway *Gateway) Status() (Rf12demoStatus, bool) {
+	gateway.mutex.RLock()
+	defer gateway.mutex.RUnlock()
+
+	return gateway.status, gateway.configVerified
+}
+
+// Record a packet received by gateway, with a good or bad CRC
+func (gateway *Gateway) packetReceived(good bool, at time.Time) {
+	gateway.mutex.Lock()
+	previous := gateway.radioStats.record(good, at)
+	gateway.mutex.Unlock()
+
+	if previous != nil {
+		log.Info("Gateway %s: %d good / %d bad packets at %s", gateway.Name, previous.Good, previous.Bad, previous.At.Format("15:04"))
+	}
+}
+
+// Returns radio statistics
+func (gateway *Gateway) RadioStats() (goodNb int, badNb int, history []RadioStatsMinute) {
+	gateway.mutex.RLock()
+	defer gateway.mutex.RUnlock()
+
+	return gateway.radioStats.GoodNb, gateway.radioStats.BadNb, gateway.radioStats.history()
+}
+
+// Record a rejected packet
+func (gateway *Gateway) parseErrorReceived(kind ParseErrorKind) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	gateway.parseErrors[kind] += 1
+}
+
+// Returns number of rejected packets, by parse error kind name
+func (gateway *Gateway) ParseErrors() map[string]int {
+	gateway.mutex.RLock()
+	defer gateway.mutex.RUnlock()
+
+	result := make(map[string]int)
+	for kind, nb := range gateway.parseErrors {
+		result[NameForParseErrorKind[kind]] = nb
+	}
+
+	return result
+}
+
+// Record a frame received from a node
+func (gateway *Gateway) nodeHeard(dataLog *Rf12demoDataLog, first bool) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+
+	stats := gateway.nodesStats[dataLog.nodeId]
+	if stats == nil {
+		stats = &GatewayNodeStats{NodeId: dataLog.nodeId}
+		gateway.nodesStats[dataLog.nodeId] = stats
+	}
+
+	stats.FramesNb += 1
+	stats.LastSeenAt = dataLog.at
+
+	if first {
+		stats.FirstNb += 1
+	}
+
+	if dataLog.hasRssi {
+		stats.recordRssi(dataLog.rssi)
+	}
+}
+
+// Record signal strength of a frame, cf. Node.recordRssi()
+func (stats *GatewayNodeStats) recordRssi(rssi int) {
+	if stats.Rssi == 0 {
+		stats.RssiMin = rssi
+		stats.RssiAvg = float64(rssi)
+	} else {
+		if rssi < stats.RssiMin {
+			stats.RssiMin = rssi
+		}
+
+		stats.RssiAvg += (float64(rssi) - stats.RssiAvg) * RSSI_AVG_WEIGHT
+	}
+
+	stats.Rssi = rssi
+}
+
+// Returns statistics for all nodes heard by gateway, sorted by node id
+func (gateway *Gateway) NodesStats() []GatewayNodeStats {
+	gateway.mutex.RLock()
+	defer gateway.mutex.RUnlock()
+
+	result := make([]GatewayNodeStats, 0)
+	for _, stats := range gateway.nodesStats {
+		result = append(result, *stats)
+	}
+
+	sort.Sort(gatewayNodesStatsById(result))
+
+	return result
+}
+
+// sort helper
+type gatewayNodesStatsById []GatewayNodeStats
+
+func (a gatewayNodesStatsById) Len() int           { return len(a) }
+func (a gatewayNodesStatsById) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a gatewayNodesStatsById) Less(i, j int) bool { return a[i].NodeId < a[j].NodeId }
+
+// cf. http://stackoverflow.com/a/17323212
+func (gateway *Gateway) toJsonifableMap() map[string]interface{} {
+	state := gateway.Reader.State()
+
+	result := map[string]interface{}{
+		"name":      gateway.Name,
+		"port":      gateway.Reader.Port(),
+		"connected": state.Connected,
+		"nodes":     gateway.NodesStats(),
+	}
+
+	if !state.ConnectedAt.IsZero() {
+		result["connected_at"] = state.ConnectedAt
+	}
+
+	if !state.DisconnectedAt.IsZero() {
+		result["disconnected_at"] = state.DisconnectedAt
+	}
+
+	if state.LastError != nil {
+		result["last_error"] = state.LastError.Error()
+	}
+
+	status, configVerified := gateway.Status()
+
+	if status.Version != 0 {
+		result["version"] = status.Version
+	}
+
+	if status.Configured {
+		result["node_id"] = status.NodeId
+		result["group"] = status.Group
+		result["band"] = status.Band
+		result["quiet"] = status.Quiet
+		result["collect"] = status.Collect
+	}
+
+	if gateway.rf12demoConf != nil {
+		result["config_verified"] = configVerified
+	}
+
+	goodNb, badNb, history := gateway.RadioStats()
+	result["radio"] = map[string]interface{}{
+		"good_nb": goodNb,
+		"bad_nb":  badNb,
+		"minutes": history,
+		"errors":  gateway.ParseErrors(),
+	}
+
+	return result
+}
@@ -1,20 +1,22 @@
 package app
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	log "code.google.com/p/log4go"
 
 	"github.com/aymerick/jeego/pkg/config"
 	"github.com/aymerick/jeego/pkg/domoticz"
+	"github.com/aymerick/jeego/pkg/serial_reader"
 	"github.com/aymerick/jeego/pkg/ws_hub"
 )
 
 const (
-	LOG_PERIOD  = 5 // in minutes
-	LOG_HISTORY = 2 // in days
+	LOG_PERIOD = 5 // in minutes
 )
 
 // Jeego
@@ -23,10 +25,27 @@ type Jeego struct {
 	Database *Database
 	WsHub    *ws_hub.WsHub
 	Domoticz *domoticz.Domoticz
+	Gateways []*Gateway
+	Payloads *PayloadQueue
+	Events   *EventBus
+
+	// current calibration session
+	Calibration      *Calibration
+	calibrationMutex sync.Mutex // web handlers run concurrently
 }
 
 func NewJeego() *Jeego {
-	return &Jeego{}
+	return &Jeego{
+		Payloads: NewPayloadQueue(),
+		Events:   NewEventBus(),
+	}
+}
+
+// Publish an event to subscribers
+func (jeego *Jeego) publishEvent(event *Event) {
+	if jeego.Events != nil {
+		jeego.Events.Publish(event)
+	}
 }
 
 func (jeego *Jeego) LoadConfig() {
@@ -79,6 +98,28 @@ func (jeego *Jeego) SetupLogging() {
 	log.Info("Logging to file: %s", jeego.Config.LogFile)
 }
 
+// Load additional node kinds definitions
+func (jeego *Jeego) SetupNodeKinds() {
+	if jeego.Config.NodeKindsFile != "" {
+		if err := LoadNodeKinds(jeego.Config.NodeKindsFile); err != nil {
+			panic(log.Critical(err))
+		}
+
+		log.Info("Node kinds loaded from: %s", jeego.Config.NodeKindsFile)
+	}
+
+	if err := LoadNodeDecoders(jeego.Config.NodeDecoders); err != nil {
+		panic(log.Critical(err))
+	}
+}
+
+// Load sensors plausibility rules
+func (jeego *Jeego) SetupPlausibilityRules() {
+	if err := LoadPlausibilityRules(jeego.Config.Plausibility); err != nil {
+		panic(log.Critical(err))
+	}
+}
+
 func (jeego *Jeego) SetupDatabase() {
 	var err error
 
@@ -102,19 +143,27 @@ func (jeego *Jeego) RunNodeLogsTicker() {
 
 	// do it right now
 	jeego.Database.insertNodeLogs()
+	jeego.Database.updateRollups()
 
 	go func() {
 		for _ = range logsTicker.C {
 			// insert logs
 			jeego.Database.insertNodeLogs()
 
+			// aggregate them before they are trimmed
+			jeego.Database.updateRollups()
+
 			// @todo send to websocket clients
 			for _, node := range jeego.Database.nodes {
 				jeego.WsHub.SendMsg([]byte(node.TextData()))
 			}
 
 			// trim old logs
-			jeego.Database.trimNodeLogs(time.Hour * 24 * LOG_HISTORY)
+			if jeego.Config.Retention.Logs > 0 {
+				jeego.Database.trimNodeLogs(time.Hour * 24 * time.Duration(jeego.Config.Retention.Logs))
+			}
+			jeego.Database.trimRollups(jeego.Config.Retention)
+			jeego.Database.trimSensorEvents(time.Hour * 24 * EVENTS_HISTORY)
 		}
 	}()
 }
@@ -141,6 +190,59 @@ func (jeego *Jeego) StartWebServer() {
 }
 
 // Start RF12demo handler
-func (jeego *Jeego) StartRf12demo() chan string {
+func (jeego *Jeego) StartRf12demo() chan *Rf12demoLine {
 	return RunRf12demo(jeego)
 }
+
+// Start reading from gateways, and send received lines to given RF12demo handler
+func (jeego *Jeego) StartGateways(handlerChan chan *Rf12demoLine) {
+	gatewaysConf := jeego.Config.Gateways
+	if len(gatewaysConf) == 0 {
+		// single gateway
+		gatewaysConf = []config.GatewayConfig{{Name: "default", Port: jeego.Config.SerialPort, Baud: jeego.Config.SerialBaud, Rf12demo: jeego.Config.Rf12demo}}
+	}
+
+	for index, gatewayConf := range gatewaysConf {
+		name := gatewayConf.Name
+		if name == "" {
+			name = fmt.Sprintf("gateway%d", index+1)
+		}
+
+		baud := gatewayConf.Baud
+		if baud == 0 {
+			baud = jeego.Config.SerialBaud
+		}
+
+		rf12demoConf := gatewayConf.Rf12demo
+		if rf12demoConf == nil {
+			rf12demoConf = jeego.Config.Rf12demo
+		}
+
+		gateway := NewGateway(name, jeego.transportForPort(gatewayConf.Port, baud), rf12demoConf)
+		gateway.Run(handlerChan)
+
+		jeego.Gateways = append(jeego.Gateways, gateway)
+	}
+}
+
+// Get a gateway
+func (jeego *Jeego) GatewayForName(name string) *Gateway {
+	for _, gateway := range jeego.Gateways {
+		if gateway.Name == name {
+			return gateway
+		}
+	}
+
+	return nil
+}
+
+// Returns transport to use for given port
+func (jeego *Jeego) transportForPort(port string, baud int) serial_reader.Transport {
+	if port == SIMULATOR_PORT {
+		log.Info("Using simulated gateway with %d nodes", jeego.Config.Simulator.NodesNb)
+
+		return NewSimulator(jeego.Config.Simulator, time.Now().UnixNano())
+	}
+
+	return serial_reader.NewTransport(port, baud)
+}
@@ -0,0 +1,422 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+const SCHEMA_VERSION_SCHEMA = `
+CREATE TABLE IF NOT EXISTS schema_version (
+    version INTEGER NOT NULL PRIMARY KEY,
+    name TEXT,
+    applied_at INTEGER NOT NULL
+);
+`
+
+// Database schema migration
+type Migration struct {
+	Version int
+	Name    string
+
+	// SQL queries
+	Queries []string
+
+	// changes that can't be done with plain SQL queries, run after queries
+	Run func(tx *sql.Tx) error
+}
+
+// Database schema migrations, in version order
+//
+// NB: never change a released migration, add a new one instead.
+var Migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "nodes and logs",
+		Queries: []string{`
+CREATE TABLE IF NOT EXISTS nodes (
+    id INTEGER NOT NULL PRIMARY KEY,
+    kind INTEGER NOT NULL,
+    updated_at INTEGER,
+    last_seen_at INTEGER,
+    name TEXT,
+    domoticz_idx TEXT,
+    temperature REAL,
+    humidity INTEGER,
+    light INTEGER,
+    motion INTEGER,
+    lowbat INTEGER,
+    vcc INTEGER
+);`, `
+CREATE TABLE IF NOT EXISTS node_logs (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    node_id INTEGER NOT NULL,
+    at INTEGER NOT NULL,
+    temperature REAL,
+    humidity INTEGER,
+    light INTEGER,
+    motion INTEGER,
+    lowbat INTEGER,
+    vcc INTEGER
+);`,
+		},
+		Run: migrateLegacyLogsTable,
+	},
+	{
+		Version: 2,
+		Name:    "signal strength",
+		Run: func(tx *sql.Tx) error {
+			return addColumns(tx, [][3]string{
+				{"nodes", "rssi", "INTEGER"},
+				{"nodes", "rssi_min", "INTEGER"},
+				{"nodes", "rssi_avg", "REAL"},
+				{"node_logs", "rssi", "INTEGER"},
+			})
+		},
+	},
+	{
+		Version: 3,
+		Name:    "generic sensors values",
+		Queries: []string{`
+CREATE TABLE IF NOT EXISTS node_values (
+    node_id INTEGER NOT NULL,
+    sensor TEXT NOT NULL,
+    value REAL,
+    PRIMARY KEY (node_id, sensor)
+);`, `
+CREATE TABLE IF NOT EXISTS node_log_values (
+    node_id INTEGER NOT NULL,
+    at INTEGER NOT NULL,
+    sensor TEXT NOT NULL,
+    value REAL,
+    PRIMARY KEY (node_id, at, sensor)
+);`,
+		},
+		Run: migrateLegacySensorsColumns,
+	},
+	{
+		Version: 4,
+		Name:    "sensors shift corrections",
+		Queries: []string{`
+CREATE TABLE IF NOT EXISTS node_sensor_settings (
+    node_id INTEGER NOT NULL,
+    sensor TEXT NOT NULL,
+    offset REAL,
+    gain REAL,
+    PRIMARY KEY (node_id, sensor)
+);`,
+		},
+		Run: func(tx *sql.Tx) error {
+			return addColumns(tx, [][3]string{
+				{"node_values", "raw_value", "REAL"},
+				{"node_log_values", "raw_value", "REAL"},
+			})
+		},
+	},
+	{
+		Version: 5,
+		Name:    "disabled sensors",
+		Run: func(tx *sql.Tx) error {
+			return addColumns(tx, [][3]string{{"node_sensor_settings", "disabled", "INTEGER"}})
+		},
+	},
+	{
+		Version: 6,
+		Name:    "reception ratio",
+		Run: func(tx *sql.Tx) error {
+			return addColumns(tx, [][3]string{{"node_logs", "reception", "REAL"}})
+		},
+	},
+	{
+		Version: 7,
+		Name:    "battery replacements",
+		Queries: []string{`
+CREATE TABLE IF NOT EXISTS battery_replacements (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    node_id INTEGER NOT NULL,
+    at INTEGER NOT NULL,
+    vcc_before INTEGER,
+    vcc_after INTEGER
+);`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "node kind changes",
+		Queries: []string{`
+CREATE TABLE IF NOT EXISTS node_kind_changes (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    node_id INTEGER NOT NULL,
+    at INTEGER NOT NULL,
+    from_kind INTEGER NOT NULL,
+    to_kind INTEGER NOT NULL
+);`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "sensor events",
+		Queries: []string{`
+CREATE TABLE IF NOT EXISTS node_sensor_events (
+    id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    node_id INTEGER NOT NULL,
+    sensor TEXT NOT NULL,
+    at INTEGER NOT NULL,
+    value REAL
+);`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "rollups",
+		Queries: []string{`
+CREATE TABLE IF NOT EXISTS node_hourly_values (
+    node_id INTEGER NOT NULL,
+    sensor TEXT NOT NULL,
+    at INTEGER NOT NULL,
+    min REAL,
+    max REAL,
+    avg REAL,
+    count INTEGER,
+    PRIMARY KEY (node_id, sensor, at)
+);`, `
+CREATE TABLE IF NOT EXISTS node_daily_values (
+    node_id INTEGER NOT NULL,
+    sensor TEXT NOT NULL,
+    at INTEGER NOT NULL,
+    min REAL,
+    max REAL,
+    avg REAL,
+    count INTEGER,
+    PRIMARY KEY (node_id, sensor, at)
+);`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "log values by log id",
+		Queries: []string{`
+CREATE TABLE node_log_values_by_log (
+    node_log_id INTEGER NOT NULL,
+    node_id INTEGER NOT NULL,
+    at INTEGER NOT NULL,
+    sensor TEXT NOT NULL,
+    value REAL,
+    raw_value REAL,
+    PRIMARY KEY (node_log_id, sensor)
+);`, `
+INSERT INTO node_log_values_by_log (node_log_id, node_id, at, sensor, value, raw_value)
+    SELECT (SELECT MAX(id) FROM node_logs WHERE node_logs.node_id = node_log_values.node_id AND node_logs.at = node_log_values.at), node_id, at, sensor, value, raw_value
+    FROM node_log_values
+    WHERE EXISTS (SELECT 1 FROM node_logs WHERE node_logs.node_id = node_log_values.node_id AND node_logs.at = node_log_values.at);`,
+			"DROP TABLE node_log_values;",
+			"ALTER TABLE node_log_values_by_log RENAME TO node_log_values;",
+			"CREATE INDEX node_log_values_node_id_at ON node_log_values (node_id, at);",
+		},
+	},
+}
+
+// Returns schema version supported by this build
+func latestSchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// Returns database schema version, 0 if database was never migrated
+func (db *Database) SchemaVersion() (int, error) {
+	var tablesNb int
+	if err := db.driver.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='schema_version'").Scan(&tablesNb); err != nil {
+		return 0, err
+	}
+
+	if tablesNb == 0 {
+		return 0, nil
+	}
+
+	var version sql.NullInt64
+	if err := db.driver.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+// Returns migrations not applied yet
+//
+// An error is returned if database schema is newer than the one supported by this build.
+func (db *Database) PendingMigrations() ([]*Migration, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	if version > latestSchemaVersion() {
+		return nil, fmt.Errorf("Database schema version %d is newer than supported version %d, please upgrade jeego", version, latestSchemaVersion())
+	}
+
+	result := make([]*Migration, 0)
+	for _, migration := range Migrations {
+		if migration.Version > version {
+			result = append(result, migration)
+		}
+	}
+
+	return result, nil
+}
+
+// Apply pending migrations, and returns them
+func (db *Database) Migrate() ([]*Migration, error) {
+	migrations, err := db.PendingMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.driver.Exec(SCHEMA_VERSION_SCHEMA); err != nil {
+		return nil, err
+	}
+
+	for _, migration := range migrations {
+		log.Info("Migrating database to version %d: %s", migration.Version, migration.Name)
+
+		if err := db.applyMigration(migration); err != nil {
+			return nil, fmt.Errorf("Migration %d (%s) failed: %s", migration.Version, migration.Name, err)
+		}
+	}
+
+	return migrations, nil
+}
+
+// Apply a migration in a transaction
+func (db *Database) applyMigration(migration *Migration) error {
+	tx, err := db.driver.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := migration.apply(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Run migration in given transaction
+func (migration *Migration) apply(tx *sql.Tx) error {
+	for _, query := range migration.Queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	if migration.Run != nil {
+		if err := migration.Run(tx); err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec("INSERT INTO schema_version(version, name, applied_at) VALUES(?, ?, ?)", migration.Version, migration.Name, time.Now().UTC().Unix())
+
+	return err
+}
+
+// Copy logs from legacy logs table
+func migrateLegacyLogsTable(tx *sql.Tx) error {
+	var tablesNb int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='logs'").Scan(&tablesNb); err != nil {
+		return err
+	}
+
+	if tablesNb == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec("INSERT INTO node_logs (node_id, at, temperature, humidity, light, motion, lowbat, vcc) SELECT node_id, at, temperature, humidity, light, motion, lowbat, vcc FROM logs WHERE NOT EXISTS (SELECT 1 FROM node_logs)")
+
+	return err
+}
+
+// Copy values from legacy sensors columns of nodes and node_logs tables
+func migrateLegacySensorsColumns(tx *sql.Tx) error {
+	columns := map[string]string{
+		"temperature": "temperature",
+		"humidity":    "humidity",
+		"light":       "light",
+		"motion":      "motion",
+		"lowbat":      "low_battery",
+		"vcc":         "vcc",
+	}
+
+	for column, sensorName := range columns {
+		if found, err := haveColumn(tx, "nodes", column); err != nil {
+			return err
+		} else if found {
+			query := fmt.Sprintf("INSERT OR IGNORE INTO node_values (node_id, sensor, value) SELECT id, ?, %s FROM nodes WHERE %s IS NOT NULL", column, column)
+			if _, err := tx.Exec(query, sensorName); err != nil {
+				return err
+			}
+		}
+
+		if found, err := haveColumn(tx, "node_logs", column); err != nil {
+			return err
+		} else if found {
+			query := fmt.Sprintf("INSERT OR IGNORE INTO node_log_values (node_id, at, sensor, value) SELECT node_id, at, ?, %s FROM node_logs WHERE %s IS NOT NULL", column, column)
+			if _, err := tx.Exec(query, sensorName); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Add given columns (table, column, type) if they don't exist yet
+//
+// Columns may already have been added by hand with the SQL scripts used before migrations.
+func addColumns(tx *sql.Tx, columns [][3]string) error {
+	for _, column := range columns {
+		found, err := haveColumn(tx, column[0], column[1])
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", column[0], column[1], column[2])); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns true if given table has given column
+func haveColumn(tx *sql.Tx, table string, column string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			ctype      string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
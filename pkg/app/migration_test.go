@@ -0,0 +1,105 @@
+package app
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MigrateNewDatabase(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	version, err := db.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, version, latestSchemaVersion())
+
+	migrations, err := db.PendingMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, len(migrations), 0)
+
+	// migrations are ordered
+	for index, migration := range Migrations {
+		assert.Equal(t, migration.Version, index+1)
+	}
+}
+
+func Test_MigrateLegacyDatabase(t *testing.T) {
+	filePath := TempFilename()
+	defer os.Remove(filePath)
+
+	driver, err := sql.Open("sqlite3", filePath)
+	assert.Nil(t, err)
+
+	// legacy schema, with signal strength column added by hand
+	_, err = driver.Exec(Migrations[0].Queries[0])
+	assert.Nil(t, err)
+	_, err = driver.Exec("ALTER TABLE nodes ADD COLUMN rssi INTEGER")
+	assert.Nil(t, err)
+	_, err = driver.Exec("INSERT INTO nodes(id, kind, updated_at, last_seen_at, name, temperature, humidity, lowbat, rssi) VALUES(3, 3, 1420070400, 1420070400, 'Attic', 21.3, 74, 0, -70)")
+	assert.Nil(t, err)
+	_, err = driver.Exec("CREATE TABLE logs (node_id INTEGER NOT NULL, at INTEGER NOT NULL, temperature REAL, humidity INTEGER, light INTEGER, motion INTEGER, lowbat INTEGER, vcc INTEGER)")
+	assert.Nil(t, err)
+	_, err = driver.Exec("INSERT INTO logs(node_id, at, temperature) VALUES(3, 1420070400, 20.5)")
+	assert.Nil(t, err)
+	driver.Close()
+
+	db := newTestDatabase(t, filePath)
+	defer db.close()
+
+	version, err := db.SchemaVersion()
+	assert.Nil(t, err)
+	assert.Equal(t, version, latestSchemaVersion())
+
+	node := db.NodeForId(3)
+	assert.Equal(t, node.Rssi, -70)
+	assert.Equal(t, node.Values, map[Sensor]float64{TEMP_SENSOR: 21.3, HUMI_SENSOR: 74, LOWBAT_SENSOR: 0})
+
+	nodeLogs := db.nodeLogs(node)
+	assert.Equal(t, len(nodeLogs), 1)
+	assert.Equal(t, nodeLogs[0].Values[TEMP_SENSOR], 20.5)
+}
+
+func Test_MigrateNewerDatabase(t *testing.T) {
+	filePath := TempFilename()
+	defer os.Remove(filePath)
+
+	db := newTestDatabase(t, filePath)
+
+	_, err := db.driver.Exec("INSERT INTO schema_version(version, name, applied_at) VALUES(?, 'future', 0)", latestSchemaVersion()+1)
+	assert.Nil(t, err)
+	db.close()
+
+	_, err = LoadDatabase(filePath)
+	assert.NotNil(t, err)
+}
+
+func Test_MigrateRollback(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	saved := Migrations
+	defer func() { Migrations = saved }()
+
+	Migrations = append(Migrations, &Migration{
+		Version: latestSchemaVersion() + 1,
+		Name:    "broken",
+		Queries: []string{"CREATE TABLE foo (id INTEGER)", "NOT SQL"},
+	})
+
+	migrations, err := db.PendingMigrations()
+	assert.Nil(t, err)
+	assert.Equal(t, len(migrations), 1)
+
+	_, err = db.Migrate()
+	assert.NotNil(t, err)
+
+	version, _ := db.SchemaVersion()
+	assert.Equal(t, version, len(saved))
+
+	var tablesNb int
+	db.driver.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='foo'").Scan(&tablesNb)
+	assert.Equal(t, tablesNb, 0)
+}
@@ -9,33 +9,41 @@ import (
 	log "code.google.com/p/log4go"
 )
 
-// node kinds
+// built-in node kinds (cf. defaultNodeKinds)
 const (
-	JEENODE_THLM_NODE = iota + 1 // Jeenode: Temperature Humidity Light Motion
-	JEENODE_THL_NODE             // Jeenode: Temperature Humidity Light
-	TINYTX_T_NODE                //  TinyTX: Temperature
-	TINYTX_TH_NODE               //  TinyTX: Temperature Humidity
-	TINYTX_TL_NODE               //  TinyTX: Temperature Light
+	JEENODE_THLM_NODE      = iota + 1 // Jeenode: Temperature Humidity Light Motion
+	JEENODE_THL_NODE                  // Jeenode: Temperature Humidity Light
+	TINYTX_T_NODE                     //  TinyTX: Temperature
+	TINYTX_TH_NODE                    //  TinyTX: Temperature Humidity
+	TINYTX_TL_NODE                    //  TinyTX: Temperature Light
+	JEENODE_POWER_NODE                // Jeenode: Power Energy
+	TINYTX_LEAK_NODE                  //  TinyTX: Leak
+	JEENODE_SMOKE_CO2_NODE            // Jeenode: Smoke CO2
 )
 
-// sensors kinds
+// stock payloads formats (cf. stockNodeKinds)
 const (
-	TEMP_SENSOR   = iota // Temperature
-	HUMI_SENSOR          // Humidity
-	LIGHT_SENSOR         // Light
-	MOTION_SENSOR        // Motion
-	LOWBAT_SENSOR        // Low Battery
-	VCC_SENSOR           // Supply voltage
+	JEELIB_ROOM_NODE    = iota + NODE_KIND_MAX + 1 // JeeLib roomNode sketch
+	TINYTX_STOCK_T_NODE                            // TinyTX stock firmware: Temperature
 )
 
 // base to compute Device ID
 const DOMOTICZ_DEVICE_ID_BASE = 2000
 
-type Sensor uint
+// domoticz device units, for nodes with several devices
+const (
+	DOMOTICZ_TEMP_HUM_UNIT = iota + 1
+	DOMOTICZ_POWER_UNIT
+	DOMOTICZ_CO2_UNIT
+	DOMOTICZ_LEAK_UNIT
+	DOMOTICZ_SMOKE_UNIT
+	DOMOTICZ_DEW_POINT_UNIT
+	DOMOTICZ_ABS_HUMI_UNIT
+	DOMOTICZ_HEAT_INDEX_UNIT
+)
 
-var AllSensors []Sensor
-var SensorsForNodeKind map[int][]Sensor
-var BitsNbForSensor map[Sensor]int
+// weight of last received signal strength in average
+const RSSI_AVG_WEIGHT = 0.1
 
 // Node
 type Node struct {
@@ -46,34 +54,31 @@ type Node struct {
 	Name        string    `json:"name"`
 	DomoticzIdx string    `json:"domoticz_idx"`
 
-	// sensors
-	Temperature float64 `json:"temperature"`
-	Humidity    uint8   `json:"humidity"`
-	Light       uint8   `json:"light"`
-	Motion      bool    `json:"motion"`
-	LowBattery  bool    `json:"low_battery"`
-	Vcc         uint    `json:"vcc"`
-}
+	// signal strength, in dBm (0 if unknown)
+	Rssi    int     `json:"rssi"`
+	RssiMin int     `json:"rssi_min"`
+	RssiAvg float64 `json:"rssi_avg"`
 
-func init() {
-	AllSensors = []Sensor{TEMP_SENSOR, HUMI_SENSOR, LIGHT_SENSOR, MOTION_SENSOR, LOWBAT_SENSOR, VCC_SENSOR}
+	// sensors values (cf. Sensor.typedValue())
+	Values map[Sensor]float64 `json:"-"`
 
-	SensorsForNodeKind = map[int][]Sensor{
-		JEENODE_THLM_NODE: {TEMP_SENSOR, HUMI_SENSOR, LIGHT_SENSOR, MOTION_SENSOR, LOWBAT_SENSOR},
-		JEENODE_THL_NODE:  {TEMP_SENSOR, HUMI_SENSOR, LIGHT_SENSOR, LOWBAT_SENSOR},
-		TINYTX_T_NODE:     {TEMP_SENSOR, VCC_SENSOR},
-		TINYTX_TH_NODE:    {TEMP_SENSOR, HUMI_SENSOR, VCC_SENSOR},
-		TINYTX_TL_NODE:    {TEMP_SENSOR, LIGHT_SENSOR, VCC_SENSOR},
-	}
+	// sensors values before shift correction
+	RawValues map[Sensor]float64 `json:"-"`
 
-	BitsNbForSensor = map[Sensor]int{
-		TEMP_SENSOR:   10, // [10 bits] Temperature: -512..+512 (tenths)
-		HUMI_SENSOR:   7,  //  [7 bits] Humidity: 0..100
-		LIGHT_SENSOR:  8,  //  [8 bits] Light: 0..255
-		MOTION_SENSOR: 1,  //   [1 bit] Motion: 0..1
-		LOWBAT_SENSOR: 1,  //   [1 bit] Low Battery: 0..1
-		VCC_SENSOR:    12, // [12 bits] Supply voltage: 0..4095 mV
-	}
+	// sensors settings
+	Settings map[Sensor]*SensorSettings `json:"-"`
+
+	// packets reception statistics
+	Reception *ReceptionStats `json:"-"`
+
+	// kind before last kind change (0 if unknown)
+	PreviousKind int `json:"previous_kind"`
+
+	// pending kind change
+	kindCandidate kindCandidate
+
+	// held and rejected values
+	plausibility plausibilityState
 }
 
 // log formatted debug message
@@ -96,9 +101,41 @@ func (node *Node) LogWarn(msg string) {
 	log.Warn("[node %d][%s] %s", node.Id, nodeName, msg)
 }
 
-// return all sensors
+// return node kind definition, or nil if node kind is unknown
+func (node *Node) kind() *NodeKind {
+	return NodeKinds[node.Kind]
+}
+
+// return all sensors, in node data order
 func (node *Node) sensors() []Sensor {
-	return SensorsForNodeKind[node.Kind]
+	kind := node.kind()
+	if kind == nil {
+		return nil
+	}
+
+	result := make([]Sensor, len(kind.Fields))
+	for index, field := range kind.Fields {
+		result[index] = field.sensor
+	}
+
+	return result
+}
+
+// return all sensors, followed by derived sensors
+func (node *Node) allSensors() []Sensor {
+	result := node.sensors()
+
+	return append(result, derivedSensors(result)...)
+}
+
+// return derived sensor definition, or nil if given sensor is not derived for that node
+func (node *Node) derivedSensorDef(sensor Sensor) *DerivedSensorDef {
+	kind := node.kind()
+	if (kind == nil) || (kind.field(sensor) != nil) {
+		return nil
+	}
+
+	return derivedSensorDef(sensor, node.sensors())
 }
 
 // return all absent sensors
@@ -116,7 +153,7 @@ func (node *Node) absentSensors() []Sensor {
 
 // check if node have given sensor
 func (node *Node) haveSensor(sensor Sensor) bool {
-	for _, nodeSensor := range node.sensors() {
+	for _, nodeSensor := range node.allSensors() {
 		if nodeSensor == sensor {
 			return true
 		}
@@ -125,49 +162,147 @@ func (node *Node) haveSensor(sensor Sensor) bool {
 	return false
 }
 
+// return sensors that are not disabled, in node data order, followed by derived sensors that have a value
+func (node *Node) enabledSensors() []Sensor {
+	result := make([]Sensor, 0)
+
+	for _, sensor := range node.allSensors() {
+		if node.sensorDisabled(sensor) {
+			continue
+		}
+
+		if _, found := node.Values[sensor]; found || (node.derivedSensorDef(sensor) == nil) {
+			result = append(result, sensor)
+		}
+	}
+
+	return result
+}
+
+// check if node have given sensor, and that it is not disabled
+func (node *Node) sensorEnabled(sensor Sensor) bool {
+	return node.haveSensor(sensor) && !node.sensorDisabled(sensor)
+}
+
+// check if given sensor is disabled
+func (node *Node) sensorDisabled(sensor Sensor) bool {
+	if settings := node.Settings[sensor]; (settings != nil) && settings.Disabled {
+		return true
+	}
+
+	// derived sensor is disabled if one of its inputs is disabled
+	if def := node.derivedSensorDef(sensor); def != nil {
+		for _, input := range def.Inputs {
+			if node.sensorDisabled(input) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // reset sensors values
 func (node *Node) ResetSensors() {
-	node.Temperature = float64(0)
-	node.Humidity = uint8(0)
-	node.Light = uint8(0)
-	node.Motion = false
-	node.LowBattery = false
-	node.Vcc = 0
+	node.Values = make(map[Sensor]float64)
+	node.RawValues = make(map[Sensor]float64)
 }
 
-// handle incoming node data
-func (node *Node) HandleData(data []byte) {
-	if node.sensors() != nil {
-		expectedLength := node.expectedDataLength()
-		if len(data) == expectedLength {
-			sensorsData := node.parseData(data)
-
-			for sensor, value := range sensorsData {
-				node.setSensorRawValue(sensor, value)
-			}
-		} else {
-			log.Error(fmt.Sprintf("Unexpected data length: %v / Expected: %d", data, expectedLength))
+// returns packets reception statistics
+func (node *Node) reception() *ReceptionStats {
+	if node.Reception == nil {
+		node.Reception = NewReceptionStats()
+	}
+
+	return node.Reception
+}
+
+// record a packet received at given time, with given data
+func (node *Node) recordReception(data []byte, at time.Time) {
+	if missed := node.reception().record(at); missed > 0 {
+		node.LogWarn(fmt.Sprintf("About %d packets missed before %s", missed, at.Format(time.RFC3339)))
+	}
+
+	if sequence, ok := node.sequence(data); ok {
+		if lost := node.reception().recordSequence(sequence); lost > 0 {
+			node.LogWarn(fmt.Sprintf("%d packets lost before sequence %d", lost, sequence))
 		}
+	}
+}
+
+// returns sequence counter from node data, if node kind has one
+func (node *Node) sequence(data []byte) (byte, bool) {
+	kind := node.kind()
+	if (kind == nil) || !kind.Sequence || (len(data) == 0) {
+		return 0, false
+	}
+
+	return data[len(data)-1], true
+}
+
+// record signal strength of a received packet
+func (node *Node) recordRssi(rssi int) {
+	if node.Rssi == 0 {
+		node.RssiMin = rssi
+		node.RssiAvg = float64(rssi)
 	} else {
-		log.Error(fmt.Sprintf("Unsupported node kind: %d", node.Kind))
+		if rssi < node.RssiMin {
+			node.RssiMin = rssi
+		}
+
+		node.RssiAvg += (float64(rssi) - node.RssiAvg) * RSSI_AVG_WEIGHT
 	}
+
+	node.Rssi = rssi
 }
 
-// returns expected node data length
-func (node *Node) expectedDataLength() int {
-	bitsNb := 0
+// handle incoming node data
+func (node *Node) HandleData(data []byte) error {
+	if err := node.checkData(data); err != nil {
+		return err
+	}
+
+	// disabled sensors are parsed too, to get other sensors bits right
+	sensorsData := node.parseData(data)
+
+	for sensor, value := range sensorsData {
+		if node.sensorDisabled(sensor) {
+			continue
+		}
 
-	for _, sensor := range node.sensors() {
-		bitsNb += BitsNbForSensor[sensor]
+		// NB: node.LastSeenAt is the reception time of that data
+		if node.checkPlausibility(sensor, node.correctSensorValue(sensor, node.computeSensorValue(sensor, value)), node.LastSeenAt) {
+			node.setSensorRawValue(sensor, value)
+		}
 	}
 
-	result := bitsNb / 8
+	node.computeDerivedValues()
+
+	return nil
+}
 
-	if (bitsNb % 8) != 0 {
-		result += 1
+// check that incoming node data can be parsed
+func (node *Node) checkData(data []byte) error {
+	if node.sensors() == nil {
+		return newParseError(UNKNOWN_KIND_ERROR, "Unsupported node kind: %d", node.Kind)
 	}
 
-	return result
+	expectedLength := node.expectedDataLength()
+	if len(data) != expectedLength {
+		return newParseError(BAD_LENGTH_ERROR, "Unexpected data length: %v / Expected: %d", data, expectedLength)
+	}
+
+	return nil
+}
+
+// returns expected node data length
+func (node *Node) expectedDataLength() int {
+	kind := node.kind()
+	if kind == nil {
+		return 0
+	}
+
+	return kind.dataLength()
 }
 
 // parse incoming node data
@@ -182,141 +317,210 @@ func (node *Node) parseData(data []byte) map[Sensor]uint64 {
 	totalBitsShift := 0
 	bytesNeeded := 0
 
-	for _, sensor := range AllSensors {
-		if node.haveSensor(sensor) {
-			value = 0
+	kind := node.kind()
+	if kind == nil {
+		return result
+	}
 
-			sensorBitsNb = BitsNbForSensor[sensor]
-			if sensorBitsNb > 64 {
-				panic(log.Critical("Wow, sensor %d needs %d bits, really ?", sensor, sensorBitsNb))
-			}
+	for _, field := range kind.Fields {
+		value = 0
 
-			totalBitsShift = curBytePos + sensorBitsNb
+		sensorBitsNb = field.Bits
 
-			bytesNeeded = totalBitsShift / 8
-			if (totalBitsShift % 8) != 0 {
-				bytesNeeded += 1
-			}
+		totalBitsShift = curBytePos + sensorBitsNb
 
-			for i := 0; i < bytesNeeded; i++ {
-				value += uint64(data[curByte+i]) << uint(8*i)
-			}
+		bytesNeeded = totalBitsShift / 8
+		if (totalBitsShift % 8) != 0 {
+			bytesNeeded += 1
+		}
 
-			value = (value >> uint(curBytePos)) & ((1 << uint(sensorBitsNb)) - 1)
+		for i := 0; (i < bytesNeeded) && (curByte+i < len(data)); i++ {
+			value += uint64(data[curByte+i]) << uint(8*i)
+		}
 
-			result[sensor] = value
+		value = (value >> uint(curBytePos)) & ((1 << uint(sensorBitsNb)) - 1)
 
-			curByte += (bytesNeeded - 1)
-			curBytePos = totalBitsShift % 8
-		}
+		result[field.sensor] = value
+
+		curByte += totalBitsShift / 8
+		curBytePos = totalBitsShift % 8
 	}
 
 	return result
 }
 
-// set given sensor value
-func (node *Node) setSensorRawValue(sensor Sensor, value uint64) {
-	switch sensor {
-	case TEMP_SENSOR:
-		node.Temperature = node.computeTemperatureValue(value)
-
-	case HUMI_SENSOR:
-		node.Humidity = node.computeHumidityValue(value)
+// encode node data, this is the reverse of parseData()
+func (node *Node) encodeData(values map[Sensor]uint64) []byte {
+	result := make([]byte, node.expectedDataLength())
 
-	case LIGHT_SENSOR:
-		node.Light = node.computeLightValue(value)
+	bitPos := 0
 
-	case MOTION_SENSOR:
-		node.Motion = node.computeMotionValue(value)
+	kind := node.kind()
+	if kind == nil {
+		return result
+	}
 
-	case LOWBAT_SENSOR:
-		node.LowBattery = node.computeLowbatValue(value)
+	for _, field := range kind.Fields {
+		sensorBitsNb := field.Bits
+		value := values[field.sensor] & ((1 << uint(sensorBitsNb)) - 1)
 
-	case VCC_SENSOR:
-		node.Vcc = node.computeVccValue(value)
+		for i := 0; i < sensorBitsNb; i++ {
+			if (value & (1 << uint(i))) != 0 {
+				result[(bitPos+i)/8] |= 1 << uint((bitPos+i)%8)
+			}
+		}
 
-	default:
-		panic(log.Critical("Unknown sensor: %d", sensor))
+		bitPos += sensorBitsNb
 	}
+
+	return result
 }
 
-// compute temperature value from raw data
-func (node *Node) computeTemperatureValue(value uint64) float64 {
-	result := int64(value)
+// set given sensor value from raw data, with shift correction
+func (node *Node) setSensorRawValue(sensor Sensor, value uint64) {
+	rawValue := node.computeSensorValue(sensor, value)
 
-	if result > 512 {
-		// negative value
-		result -= 1024
+	if node.RawValues == nil {
+		node.RawValues = make(map[Sensor]float64)
 	}
 
-	return math.Ceil(float64(result)) / 10
-}
+	node.RawValues[sensor] = rawValue
 
-// compute humidity value from raw data
-func (node *Node) computeHumidityValue(value uint64) uint8 {
-	return uint8(value)
+	node.setSensorValue(sensor, node.correctSensorValue(sensor, rawValue))
 }
 
-// compute light value from raw data
-func (node *Node) computeLightValue(value uint64) uint8 {
-	return uint8((value * 100) / 255)
+// set given sensor value
+func (node *Node) setSensorValue(sensor Sensor, value float64) {
+	if SensorDefs[sensor] == nil {
+		panic(log.Critical("Unknown sensor: %d", sensor))
+	}
+
+	if node.Values == nil {
+		node.Values = make(map[Sensor]float64)
+	}
+
+	node.Values[sensor] = value
 }
 
-// compute motion value from raw data
-func (node *Node) computeMotionValue(value uint64) bool {
-	return (value != 0)
+// apply sensor shift correction to given value
+func (node *Node) correctSensorValue(sensor Sensor, value float64) float64 {
+	if settings := node.Settings[sensor]; settings != nil {
+		return sensor.normalize(settings.correct(value))
+	}
+
+	return value
 }
 
-// compute low battery value from raw data
-func (node *Node) computeLowbatValue(value uint64) bool {
-	return (value != 0)
+// get sensor value before shift correction
+func (node *Node) sensorRawValue(sensor Sensor) float64 {
+	if value, found := node.RawValues[sensor]; found {
+		return value
+	}
+
+	return node.Values[sensor]
 }
 
-// compute vcc value from raw data
-func (node *Node) computeVccValue(value uint64) uint {
-	return uint(value)
+// set sensors settings, and apply shift corrections to current values
+func (node *Node) setSensorsSettings(settings map[Sensor]*SensorSettings) error {
+	result := make(map[Sensor]*SensorSettings)
+
+	for sensor, sensorSettings := range settings {
+		if !node.haveSensor(sensor) {
+			return fmt.Errorf("Node %d does not have sensor: %s", node.Id, sensor.Name())
+		}
+
+		if sensorSettings == nil || sensorSettings.isDefault() {
+			continue
+		}
+
+		if err := sensorSettings.validate(sensor); err != nil {
+			return err
+		}
+
+		result[sensor] = &SensorSettings{Offset: sensorSettings.Offset, Gain: sensorSettings.gain(), Disabled: sensorSettings.Disabled}
+	}
+
+	node.Settings = result
+
+	for _, sensor := range node.allSensors() {
+		if node.sensorDisabled(sensor) {
+			delete(node.Values, sensor)
+			delete(node.RawValues, sensor)
+		} else if _, found := node.RawValues[sensor]; found && (node.derivedSensorDef(sensor) == nil) {
+			node.setSensorValue(sensor, node.correctSensorValue(sensor, node.RawValues[sensor]))
+		}
+	}
+
+	node.computeDerivedValues()
+
+	return nil
 }
 
-// get sensor value
-func (node *Node) sensorValue(sensor Sensor) interface{} {
-	var result interface{}
+// compute derived sensors values, from other sensors values
+func (node *Node) computeDerivedValues() {
+	for _, sensor := range node.allSensors() {
+		def := node.derivedSensorDef(sensor)
+		if (def == nil) || node.sensorDisabled(sensor) {
+			continue
+		}
 
-	switch sensor {
-	case TEMP_SENSOR:
-		result = node.Temperature
+		available := true
+		for _, input := range def.Inputs {
+			if _, found := node.Values[input]; !found {
+				available = false
+			}
+		}
 
-	case HUMI_SENSOR:
-		result = node.Humidity
+		if !available {
+			continue
+		}
 
-	case LIGHT_SENSOR:
-		result = node.Light
+		if value, ok := def.Compute(node.Values); ok {
+			value = sensor.normalize(value)
 
-	case MOTION_SENSOR:
-		result = node.Motion
+			if node.RawValues == nil {
+				node.RawValues = make(map[Sensor]float64)
+			}
+
+			node.RawValues[sensor] = value
 
-	case LOWBAT_SENSOR:
-		result = node.LowBattery
+			node.setSensorValue(sensor, node.correctSensorValue(sensor, value))
+		}
+	}
+}
 
-	case VCC_SENSOR:
-		result = node.Vcc
+// compute sensor value from raw data, with node kind definition
+func (node *Node) computeSensorValue(sensor Sensor, value uint64) float64 {
+	result := float64(value)
 
-	default:
+	if kind := node.kind(); kind != nil {
+		if field := kind.field(sensor); field != nil {
+			result = field.value(value)
+		}
+	}
+
+	return sensor.normalize(result)
+}
+
+// get sensor value
+func (node *Node) sensorValue(sensor Sensor) interface{} {
+	if SensorDefs[sensor] == nil {
 		panic(log.Critical("Unknown sensor: %d", sensor))
 	}
 
-	return result
+	return sensor.typedValue(node.Values[sensor])
 }
 
 // text to display for debugging
 func (node *Node) TextData() string {
 	result := ""
 
-	for _, sensor := range node.sensors() {
+	for _, sensor := range node.enabledSensors() {
 		if result != "" {
 			result += " | "
 		}
 
-		result += fmt.Sprintf("%s: %v", ColNameForSensor[sensor], node.sensorValue(sensor))
+		result += fmt.Sprintf("%s: %v", sensor.Name(), node.sensorValue(sensor))
 	}
 
 	return result
@@ -328,6 +532,15 @@ func (node *Node) toJsonifableMap() map[string]interface{} {
 
 	result[node.jsonFieldName("Id")] = node.Id
 	result[node.jsonFieldName("Kind")] = node.Kind
+
+	if node.PreviousKind != 0 {
+		result[node.jsonFieldName("PreviousKind")] = node.PreviousKind
+	}
+
+	if node.kindCollision() {
+		result["kind_collision"] = node.kindCollisionJsonifableMap()
+	}
+
 	result[node.jsonFieldName("UpdatedAt")] = node.UpdatedAt
 	result[node.jsonFieldName("LastSeenAt")] = node.LastSeenAt
 	result[node.jsonFieldName("Name")] = node.Name
@@ -336,36 +549,56 @@ func (node *Node) toJsonifableMap() map[string]interface{} {
 		result[node.jsonFieldName("DomoticzIdx")] = node.DomoticzIdx
 	}
 
-	for _, sensor := range AllSensors {
-		if node.haveSensor(sensor) {
-			switch sensor {
-			case TEMP_SENSOR:
-				result[node.jsonFieldName("Temperature")] = node.Temperature
+	if node.Rssi != 0 {
+		result[node.jsonFieldName("Rssi")] = node.Rssi
+		result[node.jsonFieldName("RssiMin")] = node.RssiMin
+		result[node.jsonFieldName("RssiAvg")] = math.Floor(node.RssiAvg*10+0.5) / 10
+	}
 
-			case HUMI_SENSOR:
-				result[node.jsonFieldName("Humidity")] = node.Humidity
+	for _, sensor := range node.enabledSensors() {
+		result[sensor.Name()] = node.sensorValue(sensor)
+	}
 
-			case LIGHT_SENSOR:
-				result[node.jsonFieldName("Light")] = node.Light
+	if len(node.Settings) > 0 {
+		rawValues := make(map[string]interface{})
+		settings := make(map[string]*SensorSettings)
 
-			case MOTION_SENSOR:
-				result[node.jsonFieldName("Motion")] = node.Motion
+		for sensor, sensorSettings := range node.Settings {
+			if !sensorSettings.Disabled {
+				rawValues[sensor.Name()] = sensor.typedValue(node.sensorRawValue(sensor))
+			}
 
-			case LOWBAT_SENSOR:
-				result[node.jsonFieldName("LowBattery")] = node.LowBattery
+			settings[sensor.Name()] = sensorSettings
+		}
 
-			case VCC_SENSOR:
-				result[node.jsonFieldName("Vcc")] = node.Vcc
+		result["raw_values"] = rawValues
+		result["settings"] = settings
+	}
 
-			default:
-				panic(log.Critical("Unknown sensor: %d", sensor))
-			}
+	if (len(node.plausibility.rejected) > 0) || (len(node.plausibility.held) > 0) {
+		result["plausibility"] = node.plausibility.toJsonifableMap()
+	}
+
+	if alarms := node.Alarms(); len(alarms) > 0 {
+		names := make([]string, len(alarms))
+		for index, sensor := range alarms {
+			names[index] = sensor.Name()
 		}
+
+		result["alarms"] = names
+	}
+
+	if node.Reception != nil {
+		result["reception"] = node.Reception.toJsonifableMap(time.Now().UTC())
 	}
 
 	// this emberjs convention for async relationships retrieval
 	// @todo Move that to web.go
-	result["links"] = map[string]interface{}{"logs": fmt.Sprintf("/api/nodes/%d/logs", node.Id)}
+	result["links"] = map[string]interface{}{
+		"logs":         fmt.Sprintf("/api/nodes/%d/logs", node.Id),
+		"kind_changes": fmt.Sprintf("/api/nodes/%d/kind_changes", node.Id),
+		"events":       fmt.Sprintf("/api/nodes/%d/events", node.Id),
+	}
 
 	return result
 }
@@ -382,7 +615,7 @@ func (node *Node) DomoticzParams(hardwareId string) string {
 	result := ""
 
 	isPushable := (node.DomoticzIdx != "") || (hardwareId != "")
-	haveSensor := node.haveSensor(TEMP_SENSOR) || node.haveSensor(HUMI_SENSOR)
+	haveSensor := node.sensorEnabled(TEMP_SENSOR) || node.sensorEnabled(HUMI_SENSOR)
 
 	if isPushable && haveSensor {
 		if node.DomoticzIdx != "" {
@@ -390,16 +623,16 @@ func (node *Node) DomoticzParams(hardwareId string) string {
 		} else {
 			hid := hardwareId
 			did := DOMOTICZ_DEVICE_ID_BASE + node.Id
-			dunit := 1 // ??
+			dunit := DOMOTICZ_TEMP_HUM_UNIT
 			dsubtype := 1
 
 			// pTypeTEMP_HUM 0x50 (temperature)
 			dtype := 80
 
-			if node.haveSensor(TEMP_SENSOR) && node.haveSensor(HUMI_SENSOR) {
+			if node.sensorEnabled(TEMP_SENSOR) && node.sensorEnabled(HUMI_SENSOR) {
 				// pTypeTEMP_HUM 0x52 (temperature+humidity)
 				dtype = 82
-			} else if node.haveSensor(HUMI_SENSOR) {
+			} else if node.sensorEnabled(HUMI_SENSOR) {
 				// pTypeTEMP_HUM 0x51 (humidity)
 				dtype = 81
 			}
@@ -407,12 +640,12 @@ func (node *Node) DomoticzParams(hardwareId string) string {
 			result += fmt.Sprintf("hid=%s&did=%d&dunit=%d&dtype=%d&dsubtype=%d&nvalue=0&svalue=", hid, did, dunit, dtype, dsubtype)
 		}
 
-		if node.haveSensor(TEMP_SENSOR) {
-			result += fmt.Sprintf("%.1f;", node.Temperature)
+		if node.sensorEnabled(TEMP_SENSOR) {
+			result += fmt.Sprintf("%.1f;", node.Values[TEMP_SENSOR])
 		}
 
-		if node.haveSensor(HUMI_SENSOR) {
-			result += fmt.Sprintf("%d;", node.Humidity)
+		if node.sensorEnabled(HUMI_SENSOR) {
+			result += fmt.Sprintf("%d;", node.sensorValue(HUMI_SENSOR))
 		}
 
 		result += "0"
@@ -421,11 +654,97 @@ func (node *Node) DomoticzParams(hardwareId string) string {
 	return result
 }
 
+// query parameters parts to send to domoticz, one per device
+//
+// Devices other than temperature/humidity one are created dynamically, so they need a hardware id.
+func (node *Node) DomoticzDevicesParams(hardwareId string) []string {
+	result := make([]string, 0)
+
+	if params := node.DomoticzParams(hardwareId); params != "" {
+		result = append(result, params)
+	}
+
+	if hardwareId == "" {
+		return result
+	}
+
+	prefix := func(dunit int, dtype int, dsubtype int) string {
+		return fmt.Sprintf("hid=%s&did=%d&dunit=%d&dtype=%d&dsubtype=%d", hardwareId, DOMOTICZ_DEVICE_ID_BASE+node.Id, dunit, dtype, dsubtype)
+	}
+
+	if node.sensorEnabled(POWER_SENSOR) {
+		// pTypeGeneral 0xF3 / sTypeKwh 0x1D: svalue is "POWER;ENERGY" (W;Wh)
+		result = append(result, fmt.Sprintf("%s&nvalue=0&svalue=%d;%d", prefix(DOMOTICZ_POWER_UNIT, 243, 29), node.sensorValue(POWER_SENSOR), node.sensorValue(ENERGY_SENSOR)))
+	}
+
+	if node.sensorEnabled(CO2_SENSOR) {
+		// pTypeAirQuality 0xF9 / sTypeVoltcraft 0x1: nvalue is ppm
+		result = append(result, fmt.Sprintf("%s&nvalue=%d", prefix(DOMOTICZ_CO2_UNIT, 249, 1), node.sensorValue(CO2_SENSOR)))
+	}
+
+	derived := []struct {
+		sensor Sensor
+		dunit  int
+		dtype  int
+	}{
+		// pTypeTEMP 0x50 / sTypeTEMP1 0x1
+		{DEW_POINT_SENSOR, DOMOTICZ_DEW_POINT_UNIT, 80},
+		{HEAT_INDEX_SENSOR, DOMOTICZ_HEAT_INDEX_UNIT, 80},
+	}
+
+	for _, device := range derived {
+		if node.sensorEnabled(device.sensor) {
+			result = append(result, fmt.Sprintf("%s&nvalue=0&svalue=%.1f", prefix(device.dunit, device.dtype, 1), node.Values[device.sensor]))
+		}
+	}
+
+	if node.sensorEnabled(ABS_HUMI_SENSOR) {
+		// pTypeGeneral 0xF3 / sTypeCustom 0x1F: svalue is g/m3
+		result = append(result, fmt.Sprintf("%s&nvalue=0&svalue=%.1f", prefix(DOMOTICZ_ABS_HUMI_UNIT, 243, 31), node.Values[ABS_HUMI_SENSOR]))
+	}
+
+	alarms := []struct {
+		sensor Sensor
+		dunit  int
+		text   string
+	}{
+		{LEAK_SENSOR, DOMOTICZ_LEAK_UNIT, "Leak"},
+		{SMOKE_SENSOR, DOMOTICZ_SMOKE_UNIT, "Smoke"},
+	}
+
+	for _, alarm := range alarms {
+		if node.sensorEnabled(alarm.sensor) {
+			// pTypeGeneral 0xF3 / sTypeAlert 0x16: nvalue is alert level (1: green, 4: red)
+			level, text := 1, "OK"
+			if node.Values[alarm.sensor] != 0 {
+				level, text = 4, alarm.text
+			}
+
+			result = append(result, fmt.Sprintf("%s&nvalue=%d&svalue=%s", prefix(alarm.dunit, 243, 22), level, text))
+		}
+	}
+
+	return result
+}
+
+// returns critical sensors that are currently raised
+func (node *Node) Alarms() []Sensor {
+	result := make([]Sensor, 0)
+
+	for _, sensor := range node.enabledSensors() {
+		if sensor.Critical() && (node.Values[sensor] != 0) {
+			result = append(result, sensor)
+		}
+	}
+
+	return result
+}
+
 func (node *Node) temperaturesSerie(nodeLogs []*NodeLog) [][]string {
 	result := make([][]string, len(nodeLogs))
 
 	for index, nodeLog := range nodeLogs {
-		serieData := []string{nodeLog.At.Format(time.RFC3339), fmt.Sprintf("%v", nodeLog.Temperature)}
+		serieData := []string{nodeLog.At.Format(time.RFC3339), fmt.Sprintf("%v", nodeLog.Values[TEMP_SENSOR])}
 		result[index] = serieData
 	}
 
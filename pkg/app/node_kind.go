@@ -0,0 +1,390 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+
+	log "code.google.com/p/log4go"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+// Built-in node kinds
+//
+// Fields are packed in node data in definition order, least significant bits first.
+const defaultNodeKinds = `
+[
+	{
+		"kind": 1,
+		"name": "Jeenode: Temperature Humidity Light Motion",
+		"fields": [
+			{ "sensor": "temperature", "bits": 10, "signed": true, "scale": 0.1, "unit": "°C" },
+			{ "sensor": "humidity", "bits": 7, "unit": "%" },
+			{ "sensor": "light", "bits": 8, "scale": 0.392156862745098, "unit": "%" },
+			{ "sensor": "motion", "bits": 1 },
+			{ "sensor": "low_battery", "bits": 1 }
+		]
+	},
+	{
+		"kind": 2,
+		"name": "Jeenode: Temperature Humidity Light",
+		"fields": [
+			{ "sensor": "temperature", "bits": 10, "signed": true, "scale": 0.1, "unit": "°C" },
+			{ "sensor": "humidity", "bits": 7, "unit": "%" },
+			{ "sensor": "light", "bits": 8, "scale": 0.392156862745098, "unit": "%" },
+			{ "sensor": "low_battery", "bits": 1 }
+		]
+	},
+	{
+		"kind": 3,
+		"name": "TinyTX: Temperature",
+		"fields": [
+			{ "sensor": "temperature", "bits": 10, "signed": true, "scale": 0.1, "unit": "°C" },
+			{ "sensor": "vcc", "bits": 12, "unit": "mV" }
+		]
+	},
+	{
+		"kind": 4,
+		"name": "TinyTX: Temperature Humidity",
+		"fields": [
+			{ "sensor": "temperature", "bits": 10, "signed": true, "scale": 0.1, "unit": "°C" },
+			{ "sensor": "humidity", "bits": 7, "unit": "%" },
+			{ "sensor": "vcc", "bits": 12, "unit": "mV" }
+		]
+	},
+	{
+		"kind": 5,
+		"name": "TinyTX: Temperature Light",
+		"fields": [
+			{ "sensor": "temperature", "bits": 10, "signed": true, "scale": 0.1, "unit": "°C" },
+			{ "sensor": "light", "bits": 8, "scale": 0.392156862745098, "unit": "%" },
+			{ "sensor": "vcc", "bits": 12, "unit": "mV" }
+		]
+	},
+	{
+		"kind": 6,
+		"name": "Jeenode: Power Energy",
+		"fields": [
+			{ "sensor": "power", "bits": 16, "unit": "W" },
+			{ "sensor": "energy", "bits": 32, "unit": "Wh" }
+		]
+	},
+	{
+		"kind": 7,
+		"name": "TinyTX: Leak",
+		"fields": [
+			{ "sensor": "leak", "bits": 1 },
+			{ "sensor": "vcc", "bits": 12, "unit": "mV" }
+		]
+	},
+	{
+		"kind": 8,
+		"name": "Jeenode: Smoke CO2",
+		"fields": [
+			{ "sensor": "smoke", "bits": 1 },
+			{ "sensor": "co2", "bits": 14, "unit": "ppm" },
+			{ "sensor": "low_battery", "bits": 1 }
+		]
+	}
+]
+`
+
+// Stock payloads formats, for nodes that don't send a node kind byte (cf. NodeDecoders)
+//
+// Their kinds are above NODE_KIND_MAX, so that they never collide with node kinds sent by nodes.
+const stockNodeKinds = `
+[
+	{
+		"kind": 128,
+		"name": "JeeLib roomNode sketch",
+		"decoder": "roomnode",
+		"fields": [
+			{ "sensor": "light", "bits": 8, "scale": 0.392156862745098, "unit": "%" },
+			{ "sensor": "motion", "bits": 1 },
+			{ "sensor": "humidity", "bits": 7, "unit": "%" },
+			{ "sensor": "temperature", "bits": 10, "signed": true, "twos_complement": true, "scale": 0.1, "unit": "°C" },
+			{ "sensor": "low_battery", "bits": 1 }
+		]
+	},
+	{
+		"kind": 129,
+		"name": "TinyTX stock firmware: Temperature",
+		"decoder": "tinytx",
+		"fields": [
+			{ "sensor": "temperature", "bits": 16, "signed": true, "twos_complement": true, "scale": 0.01, "unit": "°C" },
+			{ "sensor": "vcc", "bits": 16, "unit": "mV" }
+		]
+	}
+]
+`
+
+const (
+	NODE_KIND_MAX       = 127 // node kind is coded on 7 bits
+	NODE_KIND_FIELD_MAX = 32  // max number of bits for a field
+)
+
+// Node kind definition
+type NodeKind struct {
+	Kind   int              `json:"kind"`
+	Name   string           `json:"name"`
+	Fields []*NodeKindField `json:"fields"`
+
+	// node data ends with a counter byte, incremented by node for each packet
+	Sequence bool `json:"sequence"`
+
+	// payload format name, used to assign that kind to nodes that don't send a node kind byte
+	Decoder string `json:"decoder"`
+}
+
+// Sensor field in node data
+//
+// Value is computed from raw field value with: raw * scale + offset
+type NodeKindField struct {
+	Sensor string  `json:"sensor"` // eg: temperature
+	Bits   int     `json:"bits"`
+	Signed bool    `json:"signed"` // raw values greater than half range are negative
+	Scale  float64 `json:"scale"`  // 1 if not set
+	Offset float64 `json:"offset"`
+	Unit   string  `json:"unit"`
+
+	// with signed, half range raw value is negative too (two's complement), instead of positive as with jeego sketches
+	TwosComplement bool `json:"twos_complement"`
+
+	sensor Sensor
+}
+
+var NodeKinds = loadDefaultNodeKinds()
+
+// node kind of nodes that don't send a node kind byte, by node id
+var NodeDecoders = make(map[int]int)
+
+// Returns built-in node kinds, including stock payloads formats
+func loadDefaultNodeKinds() map[int]*NodeKind {
+	kinds, err := parseNodeKinds(strings.NewReader(defaultNodeKinds))
+	if err != nil {
+		panic(log.Critical(err))
+	}
+
+	stockKinds := make([]*NodeKind, 0)
+	if err := json.NewDecoder(strings.NewReader(stockNodeKinds)).Decode(&stockKinds); err != nil {
+		panic(log.Critical(err))
+	}
+
+	for _, kind := range stockKinds {
+		if err := kind.validateFields(); err != nil {
+			panic(log.Critical(err))
+		}
+	}
+
+	result := make(map[int]*NodeKind)
+	for _, kind := range append(kinds, stockKinds...) {
+		result[kind.Kind] = kind
+	}
+
+	return result
+}
+
+// Load node kinds from given file, they are added to built-in node kinds
+//
+// A node kind defined in file replaces the built-in node kind with the same id.
+func LoadNodeKinds(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	kinds, err := parseNodeKinds(file)
+	if err != nil {
+		return fmt.Errorf("Invalid node kinds file %s: %s", filePath, err)
+	}
+
+	for _, kind := range kinds {
+		if NodeKinds[kind.Kind] != nil {
+			log.Info("Node kind %d replaced by: %s", kind.Kind, kind.Name)
+		}
+
+		NodeKinds[kind.Kind] = kind
+	}
+
+	return nil
+}
+
+// Parse and validate node kinds definitions
+func parseNodeKinds(reader io.Reader) ([]*NodeKind, error) {
+	result := make([]*NodeKind, 0)
+
+	if err := json.NewDecoder(reader).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+
+	for _, kind := range result {
+		if err := kind.validate(); err != nil {
+			return nil, err
+		}
+
+		if seen[kind.Kind] {
+			return nil, fmt.Errorf("Node kind %d defined twice", kind.Kind)
+		}
+		seen[kind.Kind] = true
+	}
+
+	return result, nil
+}
+
+// Check node kind definition
+func (kind *NodeKind) validate() error {
+	if (kind.Kind < 1) || (kind.Kind > NODE_KIND_MAX) {
+		return fmt.Errorf("Invalid node kind: %d", kind.Kind)
+	}
+
+	return kind.validateFields()
+}
+
+// Check node kind fields definitions
+func (kind *NodeKind) validateFields() error {
+	if len(kind.Fields) == 0 {
+		return fmt.Errorf("Node kind %d has no field", kind.Kind)
+	}
+
+	seen := make(map[Sensor]bool)
+
+	for _, field := range kind.Fields {
+		sensor, found := sensorForName(field.Sensor)
+		if !found {
+			return fmt.Errorf("Node kind %d: unknown sensor: %s", kind.Kind, field.Sensor)
+		}
+
+		if seen[sensor] {
+			return fmt.Errorf("Node kind %d: sensor %s defined twice", kind.Kind, field.Sensor)
+		}
+		seen[sensor] = true
+
+		if (field.Bits < 1) || (field.Bits > NODE_KIND_FIELD_MAX) {
+			return fmt.Errorf("Node kind %d: invalid bits number for sensor %s: %d", kind.Kind, field.Sensor, field.Bits)
+		}
+
+		if field.TwosComplement && !field.Signed {
+			return fmt.Errorf("Node kind %d: two's complement set for unsigned sensor %s", kind.Kind, field.Sensor)
+		}
+
+		field.sensor = sensor
+	}
+
+	return nil
+}
+
+// Assign payload formats to nodes that don't send a node kind byte
+func LoadNodeDecoders(decoders []config.NodeDecoderConfig) error {
+	result := make(map[int]int)
+
+	for _, decoder := range decoders {
+		if (decoder.NodeId < 1) || (decoder.NodeId > 30) {
+			return fmt.Errorf("Invalid decoder node id: %d", decoder.NodeId)
+		}
+
+		if result[decoder.NodeId] != 0 {
+			return fmt.Errorf("Node %d decoder defined twice", decoder.NodeId)
+		}
+
+		kind := nodeKindForDecoder(decoder.Decoder)
+		if kind == nil {
+			return fmt.Errorf("Unknown decoder for node %d: %s", decoder.NodeId, decoder.Decoder)
+		}
+
+		result[decoder.NodeId] = kind.Kind
+	}
+
+	NodeDecoders = result
+
+	return nil
+}
+
+// Returns node kind with given payload format name
+func nodeKindForDecoder(name string) *NodeKind {
+	for _, kind := range NodeKinds {
+		if (name != "") && (kind.Decoder == name) {
+			return kind
+		}
+	}
+
+	return nil
+}
+
+// Returns field for given sensor, or nil if node kind does not have that sensor
+func (kind *NodeKind) field(sensor Sensor) *NodeKindField {
+	for _, field := range kind.Fields {
+		if field.sensor == sensor {
+			return field
+		}
+	}
+
+	return nil
+}
+
+// Returns data length in bytes
+func (kind *NodeKind) dataLength() int {
+	bitsNb := 0
+
+	for _, field := range kind.Fields {
+		bitsNb += field.Bits
+	}
+
+	result := bitsNb / 8
+
+	if (bitsNb % 8) != 0 {
+		result += 1
+	}
+
+	if kind.Sequence {
+		result += 1
+	}
+
+	return result
+}
+
+// Returns field scale
+func (field *NodeKindField) scale() float64 {
+	if field.Scale == 0 {
+		return 1
+	}
+
+	return field.Scale
+}
+
+// Compute value from raw field value
+func (field *NodeKindField) value(raw uint64) float64 {
+	result := float64(raw)
+	half := uint64(1) << uint(field.Bits-1)
+
+	if field.Signed && ((raw > half) || (field.TwosComplement && (raw == half))) {
+		// negative value
+		result -= float64(uint64(1) << uint(field.Bits))
+	}
+
+	result = result*field.scale() + field.Offset
+
+	// get rid of floating point noise, eg: 213 * 0.1 = 21.300000000000001
+	return math.Floor(result*1e6+0.5) / 1e6
+}
+
+// Compute raw field value from value, this is the reverse of value()
+func (field *NodeKindField) rawValue(value float64) uint64 {
+	result := int64(math.Floor((value-field.Offset)/field.scale() + 0.5))
+
+	if field.Signed && (result < 0) {
+		result += int64(1) << uint(field.Bits)
+	}
+
+	if result < 0 {
+		result = 0
+	}
+
+	return uint64(result) & ((uint64(1) << uint(field.Bits)) - 1)
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	KIND_CHANGE_FRAMES_NB       = 3 // consecutive frames of a new kind needed to change node kind
+	KIND_COLLISION_ALTERNATIONS = 3 // kind alternations on the same node id that denote an ID collision
+	KIND_COLLISION_WINDOW       = 1 // in hours, window of kind alternations
+)
+
+// Node kind change
+type NodeKindChange struct {
+	NodeId   int       `json:"node_id"`
+	At       time.Time `json:"at"`
+	FromKind int       `json:"from_kind"`
+	ToKind   int       `json:"to_kind"`
+}
+
+// Frames of another kind received from a node
+type kindCandidate struct {
+	kind     int
+	framesNb int
+
+	// times when a pending kind change was interrupted by a frame of node kind
+	alternations []time.Time
+}
+
+// Check kind of a frame received from node, and returns a kind change if that frame confirms it
+//
+// A frame of another kind is rejected until KIND_CHANGE_FRAMES_NB consecutive frames of that kind
+// are received, so that a corrupted packet does not wipe node data.
+func (node *Node) checkKind(kind int, at time.Time) (*NodeKindChange, error) {
+	candidate := &node.kindCandidate
+
+	// trim alternations
+	limit := at.Add(-time.Hour * KIND_COLLISION_WINDOW)
+	for (len(candidate.alternations) > 0) && candidate.alternations[0].Before(limit) {
+		candidate.alternations = candidate.alternations[1:]
+	}
+
+	if kind == node.Kind {
+		if candidate.framesNb > 0 {
+			// pending kind change interrupted
+			candidate.framesNb = 0
+			candidate.alternations = append(candidate.alternations, at)
+
+			if len(candidate.alternations) == KIND_COLLISION_ALTERNATIONS {
+				node.LogWarn(fmt.Sprintf("ID collision suspected: kinds %d and %d alternate", node.Kind, candidate.kind))
+			}
+		}
+
+		return nil, nil
+	}
+
+	if kind != candidate.kind {
+		candidate.kind = kind
+		candidate.framesNb = 0
+	}
+
+	candidate.framesNb += 1
+
+	if candidate.framesNb < KIND_CHANGE_FRAMES_NB {
+		return nil, newParseError(KIND_MISMATCH_ERROR, "Node %d kind is %d, received kind %d (%d/%d)", node.Id, node.Kind, kind, candidate.framesNb, KIND_CHANGE_FRAMES_NB)
+	}
+
+	result := &NodeKindChange{
+		NodeId:   node.Id,
+		At:       at,
+		FromKind: node.Kind,
+		ToKind:   kind,
+	}
+
+	node.PreviousKind = node.Kind
+	node.Kind = kind
+
+	// previous kind is now the candidate
+	candidate.kind = result.FromKind
+	candidate.framesNb = 0
+
+	return result, nil
+}
+
+// Returns true if another node seems to use the same id
+func (node *Node) kindCollision() bool {
+	return len(node.kindCandidate.alternations) >= KIND_COLLISION_ALTERNATIONS
+}
+
+// cf. http://stackoverflow.com/a/17323212
+func (node *Node) kindCollisionJsonifableMap() map[string]interface{} {
+	return map[string]interface{}{
+		"kinds":           []int{node.Kind, node.kindCandidate.kind},
+		"alternations_nb": len(node.kindCandidate.alternations),
+		"last_at":         node.kindCandidate.alternations[len(node.kindCandidate.alternations)-1],
+	}
+}
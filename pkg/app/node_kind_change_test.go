@@ -0,0 +1,60 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckKind(t *testing.T) {
+	node := &Node{Id: 3, Kind: TINYTX_T_NODE}
+
+	at := time.Now().UTC()
+
+	change, err := node.checkKind(TINYTX_T_NODE, at)
+	assert.Nil(t, change)
+	assert.Nil(t, err)
+
+	// candidate kind is reset by another kind
+	node.checkKind(JEENODE_THLM_NODE, at)
+	node.checkKind(TINYTX_TH_NODE, at)
+	change, err = node.checkKind(JEENODE_THLM_NODE, at)
+	assert.Nil(t, change)
+	assert.Equal(t, parseErrorKind(err), KIND_MISMATCH_ERROR)
+
+	node.checkKind(JEENODE_THLM_NODE, at)
+	change, err = node.checkKind(JEENODE_THLM_NODE, at)
+	assert.Nil(t, err)
+	assert.Equal(t, *change, NodeKindChange{NodeId: 3, At: at, FromKind: TINYTX_T_NODE, ToKind: JEENODE_THLM_NODE})
+	assert.Equal(t, node.Kind, JEENODE_THLM_NODE)
+	assert.Equal(t, node.PreviousKind, TINYTX_T_NODE)
+	assert.False(t, node.kindCollision())
+}
+
+func Test_CheckKindCollision(t *testing.T) {
+	node := &Node{Id: 3, Kind: TINYTX_T_NODE}
+
+	at := time.Now().UTC()
+
+	// two nodes share the same id
+	for i := 0; i < KIND_COLLISION_ALTERNATIONS; i++ {
+		_, err := node.checkKind(JEENODE_THLM_NODE, at.Add(time.Minute*time.Duration(i)))
+		assert.NotNil(t, err)
+
+		change, err := node.checkKind(TINYTX_T_NODE, at.Add(time.Minute*time.Duration(i)+time.Second))
+		assert.Nil(t, change)
+		assert.Nil(t, err)
+	}
+
+	assert.Equal(t, node.Kind, TINYTX_T_NODE)
+	assert.True(t, node.kindCollision())
+
+	collision := node.kindCollisionJsonifableMap()
+	assert.Equal(t, collision["kinds"], []int{TINYTX_T_NODE, JEENODE_THLM_NODE})
+	assert.Equal(t, collision["alternations_nb"], KIND_COLLISION_ALTERNATIONS)
+
+	// alternations expire
+	node.checkKind(TINYTX_T_NODE, at.Add(time.Hour*KIND_COLLISION_WINDOW*2))
+	assert.False(t, node.kindCollision())
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+func Test_DefaultNodeKinds(t *testing.T) {
+	assert.Equal(t, len(NodeKinds), 10)
+
+	kind := NodeKinds[JEENODE_THLM_NODE]
+	assert.Equal(t, len(kind.Fields), 5)
+	assert.Equal(t, kind.dataLength(), 4)
+	assert.Equal(t, kind.field(TEMP_SENSOR).Unit, "°C")
+	assert.Nil(t, kind.field(VCC_SENSOR))
+
+	// jeego sketches decode half range as positive, stock sketches use two's complement
+	assert.Equal(t, kind.field(TEMP_SENSOR).value(512), 51.2)
+	assert.Equal(t, NodeKinds[JEELIB_ROOM_NODE].field(TEMP_SENSOR).value(512), -51.2)
+	assert.Equal(t, NodeKinds[TINYTX_STOCK_T_NODE].field(TEMP_SENSOR).value(32768), -327.68)
+}
+
+func Test_ParseNodeKinds(t *testing.T) {
+	kinds, err := parseNodeKinds(strings.NewReader(`[{"kind": 10, "name": "Byte node", "fields": [{"sensor": "humidity", "bits": 8}, {"sensor": "temperature", "bits": 16, "signed": true, "scale": 0.01, "offset": -10}]}]`))
+	assert.Nil(t, err)
+	assert.Equal(t, len(kinds), 1)
+	assert.Equal(t, kinds[0].Fields[1].sensor, Sensor(TEMP_SENSOR))
+
+	_, err = parseNodeKinds(strings.NewReader(`[{"kind": 128, "fields": [{"sensor": "humidity", "bits": 8}]}]`))
+	assert.NotNil(t, err)
+
+	_, err = parseNodeKinds(strings.NewReader(`[{"kind": 10, "fields": []}]`))
+	assert.NotNil(t, err)
+
+	_, err = parseNodeKinds(strings.NewReader(`[{"kind": 10, "fields": [{"sensor": "pressure", "bits": 8}]}]`))
+	assert.NotNil(t, err)
+
+	_, err = parseNodeKinds(strings.NewReader(`[{"kind": 10, "fields": [{"sensor": "humidity", "bits": 33}]}]`))
+	assert.NotNil(t, err)
+
+	_, err = parseNodeKinds(strings.NewReader(`[{"kind": 10, "fields": [{"sensor": "humidity", "bits": 8}, {"sensor": "humidity", "bits": 8}]}]`))
+	assert.NotNil(t, err)
+
+	_, err = parseNodeKinds(strings.NewReader(`[{"kind": 10, "fields": [{"sensor": "humidity", "bits": 8}]}, {"kind": 10, "fields": [{"sensor": "light", "bits": 8}]}]`))
+	assert.NotNil(t, err)
+}
+
+func Test_NodeKindFieldValue(t *testing.T) {
+	field := &NodeKindField{Bits: 16, Signed: true, Scale: 0.01, Offset: -10}
+
+	assert.Equal(t, field.value(2345), 13.45)
+	assert.Equal(t, field.value(65535), -10.01)
+	assert.Equal(t, field.rawValue(13.45), uint64(2345))
+	assert.Equal(t, field.rawValue(-10.01), uint64(65535))
+
+	field = &NodeKindField{Bits: 8}
+
+	assert.Equal(t, field.value(200), float64(200))
+	assert.Equal(t, field.rawValue(200), uint64(200))
+
+	// half range boundaries
+	for _, bits := range []int{8, 10, 16} {
+		half := uint64(1) << uint(bits-1)
+
+		field = &NodeKindField{Bits: bits, Signed: true}
+		assert.Equal(t, field.value(half), float64(half))
+		assert.Equal(t, field.value(half+1), -float64(half-1))
+
+		field = &NodeKindField{Bits: bits, Signed: true, TwosComplement: true}
+		assert.Equal(t, field.value(half-1), float64(half-1))
+		assert.Equal(t, field.value(half), -float64(half))
+		assert.Equal(t, field.rawValue(-float64(half)), half)
+	}
+
+	// jeego sketches
+	field = &NodeKindField{Bits: 10, Signed: true, Scale: 0.1}
+	assert.Equal(t, field.value(512), 51.2)
+
+	field = &NodeKindField{Bits: 10, Signed: true, Scale: 0.1, TwosComplement: true}
+	assert.Equal(t, field.value(512), -51.2)
+}
+
+func Test_LoadNodeKinds(t *testing.T) {
+	file, err := ioutil.TempFile("", "jeego-node-kinds")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+
+	file.WriteString(`[{"kind": 10, "name": "Byte node", "fields": [{"sensor": "humidity", "bits": 8}, {"sensor": "temperature", "bits": 16, "signed": true, "scale": 0.01, "offset": -10}]}]`)
+	file.Close()
+
+	assert.Nil(t, LoadNodeKinds(file.Name()))
+	defer delete(NodeKinds, 10)
+
+	// byte aligned fields
+	node := &Node{Kind: 10}
+	assert.Equal(t, node.sensors(), []Sensor{HUMI_SENSOR, TEMP_SENSOR})
+	assert.Equal(t, node.expectedDataLength(), 3)
+
+	assert.Nil(t, node.HandleData([]byte{55, 0x29, 0x09}))
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(55))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), 13.45)
+
+	assert.Equal(t, node.encodeData(map[Sensor]uint64{HUMI_SENSOR: 55, TEMP_SENSOR: 2345}), []byte{55, 0x29, 0x09})
+
+	assert.NotNil(t, LoadNodeKinds(file.Name()+".missing"))
+}
+
+func Test_LoadNodeDecoders(t *testing.T) {
+	defer LoadNodeDecoders(nil)
+
+	assert.NotNil(t, LoadNodeDecoders([]config.NodeDecoderConfig{{NodeId: 0, Decoder: "roomnode"}}))
+	assert.NotNil(t, LoadNodeDecoders([]config.NodeDecoderConfig{{NodeId: 31, Decoder: "roomnode"}}))
+	assert.NotNil(t, LoadNodeDecoders([]config.NodeDecoderConfig{{NodeId: 5, Decoder: "foo"}}))
+	assert.NotNil(t, LoadNodeDecoders([]config.NodeDecoderConfig{{NodeId: 5, Decoder: "roomnode"}, {NodeId: 5, Decoder: "tinytx"}}))
+
+	assert.Nil(t, LoadNodeDecoders([]config.NodeDecoderConfig{{NodeId: 5, Decoder: "roomnode"}, {NodeId: 6, Decoder: "tinytx"}}))
+	assert.Equal(t, NodeDecoders, map[int]int{5: JEELIB_ROOM_NODE, 6: TINYTX_STOCK_T_NODE})
+
+	// roomNode: light 200, moved, humi 55%, 21.3°C
+	dataLog, err := parseLine("OK 37 200 111 213 0")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.nodeId, 5)
+	assert.True(t, dataLog.ack)
+	assert.Equal(t, dataLog.nodeKind, JEELIB_ROOM_NODE)
+
+	node := &Node{Id: 5, Kind: dataLog.nodeKind}
+	assert.Nil(t, node.HandleData(dataLog.data))
+	assert.Equal(t, node.sensorValue(LIGHT_SENSOR), int64(78))
+	assert.Equal(t, node.sensorValue(MOTION_SENSOR), true)
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(55))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), 21.3)
+	assert.Equal(t, node.sensorValue(LOWBAT_SENSOR), false)
+	assert.Equal(t, node.encodeData(map[Sensor]uint64{LIGHT_SENSOR: 200, MOTION_SENSOR: 1, HUMI_SENSOR: 55, TEMP_SENSOR: 213}), dataLog.data)
+
+	// TinyTX: -5.25°C, 3300 mV
+	dataLog, err = parseLine("OK 6 243 253 228 12")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.nodeKind, TINYTX_STOCK_T_NODE)
+
+	node = &Node{Id: 6, Kind: dataLog.nodeKind}
+	assert.Nil(t, node.HandleData(dataLog.data))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), -5.25)
+	assert.Equal(t, node.sensorValue(VCC_SENSOR), int64(3300))
+
+	// other nodes still send a node kind byte
+	dataLog, err = parseLine("OK 3 3 18 113 49")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.nodeKind, TINYTX_T_NODE)
+}
@@ -3,21 +3,23 @@ package app
 import (
 	"reflect"
 	"time"
-
-	log "code.google.com/p/log4go"
 )
 
 // node sensors values logged in database
 type NodeLog struct {
-	Id          int       `json:"id"`
-	NodeId      int       `json:"node_id"`
-	At          time.Time `json:"at"`
-	Temperature float64   `json:"temperature"`
-	Humidity    uint8     `json:"humidity"`
-	Light       uint8     `json:"light"`
-	Motion      bool      `json:"motion"`
-	LowBattery  bool      `json:"low_battery"`
-	Vcc         uint      `json:"vcc"`
+	Id     int       `json:"id"`
+	NodeId int       `json:"node_id"`
+	At     time.Time `json:"at"`
+	Rssi   int       `json:"rssi"`
+
+	// reception ratio during last RECEPTION_LOG_WINDOW hours (0 if unknown)
+	Reception float64 `json:"reception"`
+
+	// sensors values (cf. Sensor.typedValue())
+	Values map[Sensor]float64 `json:"-"`
+
+	// sensors values before shift correction
+	RawValues map[Sensor]float64 `json:"-"`
 }
 
 // cf. http://stackoverflow.com/a/17323212
@@ -28,30 +30,17 @@ func (nodeLog *NodeLog) toJsonifableMap(node *Node) map[string]interface{} {
 	result[nodeLog.jsonFieldName("NodeId")] = nodeLog.NodeId
 	result[nodeLog.jsonFieldName("At")] = nodeLog.At.UTC()
 
-	for _, sensor := range AllSensors {
-		if node.haveSensor(sensor) {
-			switch sensor {
-			case TEMP_SENSOR:
-				result[nodeLog.jsonFieldName("Temperature")] = nodeLog.Temperature
-
-			case HUMI_SENSOR:
-				result[nodeLog.jsonFieldName("Humidity")] = nodeLog.Humidity
-
-			case LIGHT_SENSOR:
-				result[nodeLog.jsonFieldName("Light")] = nodeLog.Light
-
-			case MOTION_SENSOR:
-				result[nodeLog.jsonFieldName("Motion")] = nodeLog.Motion
-
-			case LOWBAT_SENSOR:
-				result[nodeLog.jsonFieldName("LowBattery")] = nodeLog.LowBattery
+	if nodeLog.Rssi != 0 {
+		result[nodeLog.jsonFieldName("Rssi")] = nodeLog.Rssi
+	}
 
-			case VCC_SENSOR:
-				result[nodeLog.jsonFieldName("Vcc")] = nodeLog.Vcc
+	if nodeLog.Reception != 0 {
+		result[nodeLog.jsonFieldName("Reception")] = nodeLog.Reception
+	}
 
-			default:
-				panic(log.Critical("Unknown sensor: %d", sensor))
-			}
+	for _, sensor := range node.enabledSensors() {
+		if value, found := nodeLog.Values[sensor]; found {
+			result[sensor.Name()] = sensor.typedValue(value)
 		}
 	}
 
@@ -3,6 +3,7 @@ package app
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,7 +23,7 @@ func Test_JeenodeTHLM_Sensors(t *testing.T) {
 func Test_JeenodeTHLM_AbsentSensors(t *testing.T) {
 	node := &Node{Kind: JEENODE_THLM_NODE}
 
-	expected := []Sensor{VCC_SENSOR}
+	expected := []Sensor{VCC_SENSOR, POWER_SENSOR, ENERGY_SENSOR, LEAK_SENSOR, SMOKE_SENSOR, CO2_SENSOR}
 
 	assert.True(t, reflect.DeepEqual(node.absentSensors(), expected))
 }
@@ -87,7 +88,7 @@ func Test_JeenodeTHL_Sensors(t *testing.T) {
 func Test_JeenodeTHL_AbsentSensors(t *testing.T) {
 	node := &Node{Kind: JEENODE_THL_NODE}
 
-	expected := []Sensor{MOTION_SENSOR, VCC_SENSOR}
+	expected := []Sensor{MOTION_SENSOR, VCC_SENSOR, POWER_SENSOR, ENERGY_SENSOR, LEAK_SENSOR, SMOKE_SENSOR, CO2_SENSOR}
 
 	assert.True(t, reflect.DeepEqual(node.absentSensors(), expected))
 }
@@ -151,7 +152,7 @@ func Test_TinyTxT_Sensors(t *testing.T) {
 func Test_TinyTxT_AbsentSensors(t *testing.T) {
 	node := &Node{Kind: TINYTX_T_NODE}
 
-	expected := []Sensor{HUMI_SENSOR, LIGHT_SENSOR, MOTION_SENSOR, LOWBAT_SENSOR}
+	expected := []Sensor{HUMI_SENSOR, LIGHT_SENSOR, MOTION_SENSOR, LOWBAT_SENSOR, POWER_SENSOR, ENERGY_SENSOR, LEAK_SENSOR, SMOKE_SENSOR, CO2_SENSOR, DEW_POINT_SENSOR, ABS_HUMI_SENSOR, HEAT_INDEX_SENSOR}
 
 	assert.True(t, reflect.DeepEqual(node.absentSensors(), expected))
 }
@@ -306,43 +307,43 @@ func Test_ComputeTemperatureValue(t *testing.T) {
 	value = 0
 	expected = 0
 
-	if result := node.computeTemperatureValue(value); result != expected {
-		t.Errorf("computeTemperatureValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(TEMP_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(TEMP_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 213
 	expected = 21.3
 
-	if result := node.computeTemperatureValue(value); result != expected {
-		t.Errorf("computeTemperatureValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(TEMP_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(TEMP_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 512
 	expected = 51.2
 
-	if result := node.computeTemperatureValue(value); result != expected {
-		t.Errorf("computeTemperatureValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(TEMP_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(TEMP_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 1024
 	expected = 0
 
-	if result := node.computeTemperatureValue(value); result != expected {
-		t.Errorf("computeTemperatureValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(TEMP_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(TEMP_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 614
 	expected = -41.0
 
-	if result := node.computeTemperatureValue(value); result != expected {
-		t.Errorf("computeTemperatureValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(TEMP_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(TEMP_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 1012
 	expected = -1.2
 
-	if result := node.computeTemperatureValue(value); result != expected {
-		t.Errorf("computeTemperatureValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(TEMP_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(TEMP_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 }
 
@@ -350,27 +351,27 @@ func Test_ComputeHumidityValue(t *testing.T) {
 	node := &Node{Kind: JEENODE_THLM_NODE}
 
 	var value uint64
-	var expected uint8
+	var expected float64
 
 	value = 0
 	expected = 0
 
-	if result := node.computeHumidityValue(value); result != expected {
-		t.Errorf("computeHumidityValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(HUMI_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(HUMI_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 74
 	expected = 74
 
-	if result := node.computeHumidityValue(value); result != expected {
-		t.Errorf("computeHumidityValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(HUMI_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(HUMI_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 100
 	expected = 100
 
-	if result := node.computeHumidityValue(value); result != expected {
-		t.Errorf("computeHumidityValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(HUMI_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(HUMI_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 }
 
@@ -378,34 +379,34 @@ func Test_ComputeLightValue(t *testing.T) {
 	node := &Node{Kind: JEENODE_THLM_NODE}
 
 	var value uint64
-	var expected uint8
+	var expected float64
 
 	value = 0
 	expected = 0
 
-	if result := node.computeLightValue(value); result != expected {
-		t.Errorf("computeLightValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(LIGHT_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(LIGHT_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 255
 	expected = 100
 
-	if result := node.computeLightValue(value); result != expected {
-		t.Errorf("computeLightValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(LIGHT_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(LIGHT_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 128
 	expected = 50
 
-	if result := node.computeLightValue(value); result != expected {
-		t.Errorf("computeLightValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(LIGHT_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(LIGHT_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 156
 	expected = 61
 
-	if result := node.computeLightValue(value); result != expected {
-		t.Errorf("computeLightValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(LIGHT_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(LIGHT_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 }
 
@@ -418,15 +419,15 @@ func Test_ComputeMotionValue(t *testing.T) {
 	value = 0
 	expected = false
 
-	if result := node.computeMotionValue(value); result != expected {
-		t.Errorf("computeMotionValue(%v) = %v | expected: %v", value, result, expected)
+	if result := (node.computeSensorValue(MOTION_SENSOR, value) != 0); result != expected {
+		t.Errorf("computeSensorValue(MOTION_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 1
 	expected = true
 
-	if result := node.computeMotionValue(value); result != expected {
-		t.Errorf("computeMotionValue(%v) = %v | expected: %v", value, result, expected)
+	if result := (node.computeSensorValue(MOTION_SENSOR, value) != 0); result != expected {
+		t.Errorf("computeSensorValue(MOTION_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 }
 
@@ -439,15 +440,15 @@ func Test_ComputeLowbatValue(t *testing.T) {
 	value = 0
 	expected = false
 
-	if result := node.computeLowbatValue(value); result != expected {
-		t.Errorf("computeLowbatValue(%v) = %v | expected: %v", value, result, expected)
+	if result := (node.computeSensorValue(LOWBAT_SENSOR, value) != 0); result != expected {
+		t.Errorf("computeSensorValue(LOWBAT_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 1
 	expected = true
 
-	if result := node.computeLowbatValue(value); result != expected {
-		t.Errorf("computeLowbatValue(%v) = %v | expected: %v", value, result, expected)
+	if result := (node.computeSensorValue(LOWBAT_SENSOR, value) != 0); result != expected {
+		t.Errorf("computeSensorValue(LOWBAT_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 }
 
@@ -455,32 +456,32 @@ func Test_ComputeVccValue(t *testing.T) {
 	node := &Node{Kind: TINYTX_T_NODE}
 
 	var value uint64
-	var expected uint
+	var expected float64
 
 	value = 3142
 	expected = 3142
 
-	if result := node.computeVccValue(value); result != expected {
-		t.Errorf("computeVccValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(VCC_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(VCC_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 
 	value = 3000
 	expected = 3000
 
-	if result := node.computeVccValue(value); result != expected {
-		t.Errorf("computeVccValue(%v) = %v | expected: %v", value, result, expected)
+	if result := node.computeSensorValue(VCC_SENSOR, value); result != expected {
+		t.Errorf("computeSensorValue(VCC_SENSOR, %v) = %v | expected: %v", value, result, expected)
 	}
 }
 
 func Test_SetSensorRawValue(t *testing.T) {
 	node := &Node{Kind: JEENODE_THLM_NODE}
 
-	assert.Equal(t, node.Temperature, float64(0))
-	assert.Equal(t, node.Humidity, uint8(0))
-	assert.Equal(t, node.Light, uint8(0))
-	assert.Equal(t, node.Motion, false)
-	assert.Equal(t, node.LowBattery, false)
-	assert.Equal(t, node.Vcc, uint(0))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), float64(0))
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(0))
+	assert.Equal(t, node.sensorValue(LIGHT_SENSOR), int64(0))
+	assert.Equal(t, node.sensorValue(MOTION_SENSOR), false)
+	assert.Equal(t, node.sensorValue(LOWBAT_SENSOR), false)
+	assert.Equal(t, node.sensorValue(VCC_SENSOR), int64(0))
 
 	node.setSensorRawValue(TEMP_SENSOR, uint64(213))
 	node.setSensorRawValue(HUMI_SENSOR, uint64(74))
@@ -489,31 +490,175 @@ func Test_SetSensorRawValue(t *testing.T) {
 	node.setSensorRawValue(LOWBAT_SENSOR, uint64(1))
 	node.setSensorRawValue(VCC_SENSOR, uint64(3142))
 
-	assert.Equal(t, node.Temperature, float64(21.3))
-	assert.Equal(t, node.Humidity, uint8(74))
-	assert.Equal(t, node.Light, uint8(61))
-	assert.Equal(t, node.Motion, true)
-	assert.Equal(t, node.LowBattery, true)
-	assert.Equal(t, node.Vcc, uint(3142))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), float64(21.3))
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(74))
+	assert.Equal(t, node.sensorValue(LIGHT_SENSOR), int64(61))
+	assert.Equal(t, node.sensorValue(MOTION_SENSOR), true)
+	assert.Equal(t, node.sensorValue(LOWBAT_SENSOR), true)
+	assert.Equal(t, node.sensorValue(VCC_SENSOR), int64(3142))
 }
 
 func Test_TextData(t *testing.T) {
 	node := &Node{
-		Kind:        JEENODE_THLM_NODE,
-		Temperature: float64(21.3),
-		Humidity:    uint8(74),
-		Light:       uint8(61),
-		Motion:      true,
-		LowBattery:  false,
+		Kind:   JEENODE_THLM_NODE,
+		Values: map[Sensor]float64{TEMP_SENSOR: 21.3, HUMI_SENSOR: 74, LIGHT_SENSOR: 61, MOTION_SENSOR: 1, LOWBAT_SENSOR: 0},
 	}
 
-	assert.Equal(t, node.TextData(), "temperature: 21.3 | humidity: 74 | light: 61 | motion: true | lowbat: false")
+	assert.Equal(t, node.TextData(), "temperature: 21.3 | humidity: 74 | light: 61 | motion: true | low_battery: false")
 
 	node = &Node{
-		Kind:        TINYTX_T_NODE,
-		Temperature: float64(21.3),
-		Vcc:         uint(3142),
+		Kind:   TINYTX_T_NODE,
+		Values: map[Sensor]float64{TEMP_SENSOR: 21.3, VCC_SENSOR: 3142},
 	}
 
 	assert.Equal(t, node.TextData(), "temperature: 21.3 | vcc: 3142")
 }
+
+func Test_EncodeData(t *testing.T) {
+	node := &Node{Kind: JEENODE_THLM_NODE}
+	values := map[Sensor]uint64{TEMP_SENSOR: 213, HUMI_SENSOR: 74, LIGHT_SENSOR: 156, MOTION_SENSOR: 1, LOWBAT_SENSOR: 0}
+	assert.Equal(t, node.encodeData(values), []byte{213, 40, 57, 3})
+
+	node = &Node{Kind: JEENODE_THL_NODE}
+	values = map[Sensor]uint64{TEMP_SENSOR: 210, HUMI_SENSOR: 50, LIGHT_SENSOR: 184, LOWBAT_SENSOR: 0}
+	assert.Equal(t, node.encodeData(values), []byte{210, 200, 112, 1})
+
+	node = &Node{Kind: TINYTX_T_NODE}
+	values = map[Sensor]uint64{TEMP_SENSOR: 274, VCC_SENSOR: 3164}
+	assert.Equal(t, node.encodeData(values), []byte{18, 113, 49})
+
+	node = &Node{Kind: TINYTX_TH_NODE}
+	values = map[Sensor]uint64{TEMP_SENSOR: 274, HUMI_SENSOR: 50, VCC_SENSOR: 3164}
+	assert.Equal(t, node.encodeData(values), []byte{18, 201, 184, 24})
+
+	node = &Node{Kind: TINYTX_TL_NODE}
+	values = map[Sensor]uint64{TEMP_SENSOR: 245, LIGHT_SENSOR: 241, VCC_SENSOR: 3155}
+	assert.Equal(t, node.encodeData(values), []byte{245, 196, 79, 49})
+}
+
+func Test_RecordRssi(t *testing.T) {
+	node := &Node{Kind: JEENODE_THLM_NODE}
+
+	node.recordRssi(-70)
+	assert.Equal(t, node.Rssi, -70)
+	assert.Equal(t, node.RssiMin, -70)
+	assert.Equal(t, node.RssiAvg, float64(-70))
+
+	node.recordRssi(-80)
+	assert.Equal(t, node.Rssi, -80)
+	assert.Equal(t, node.RssiMin, -80)
+	assert.Equal(t, node.RssiAvg, float64(-71))
+
+	node.recordRssi(-60)
+	assert.Equal(t, node.Rssi, -60)
+	assert.Equal(t, node.RssiMin, -80)
+	assert.Equal(t, node.RssiAvg, float64(-69.9))
+}
+
+func Test_NewSensorsKinds(t *testing.T) {
+	node := &Node{Id: 4, Kind: JEENODE_POWER_NODE}
+	assert.Nil(t, node.HandleData([]byte{0xDC, 0x05, 0x40, 0xE2, 0x01, 0x00}))
+	assert.Equal(t, node.sensorValue(POWER_SENSOR), int64(1500))
+	assert.Equal(t, node.sensorValue(ENERGY_SENSOR), int64(123456))
+
+	assert.Equal(t, node.DomoticzDevicesParams("1"), []string{"hid=1&did=2004&dunit=2&dtype=243&dsubtype=29&nvalue=0&svalue=1500;123456"})
+
+	node = &Node{Id: 5, Kind: TINYTX_LEAK_NODE}
+	assert.Nil(t, node.HandleData([]byte{113, 23}))
+	assert.Equal(t, node.sensorValue(LEAK_SENSOR), true)
+	assert.Equal(t, node.sensorValue(VCC_SENSOR), int64(3000))
+	assert.Equal(t, node.Alarms(), []Sensor{LEAK_SENSOR})
+	assert.Equal(t, node.toJsonifableMap()["alarms"], []string{"leak"})
+
+	assert.Equal(t, node.DomoticzDevicesParams("1"), []string{"hid=1&did=2005&dunit=4&dtype=243&dsubtype=22&nvalue=4&svalue=Leak"})
+	assert.Equal(t, node.DomoticzDevicesParams(""), []string{})
+
+	node = &Node{Id: 6, Kind: JEENODE_SMOKE_CO2_NODE}
+	assert.Nil(t, node.HandleData(node.encodeData(map[Sensor]uint64{SMOKE_SENSOR: 0, CO2_SENSOR: 850, LOWBAT_SENSOR: 0})))
+	assert.Equal(t, node.sensorValue(CO2_SENSOR), int64(850))
+	assert.Equal(t, len(node.Alarms()), 0)
+
+	assert.Equal(t, node.DomoticzDevicesParams("1"), []string{
+		"hid=1&did=2006&dunit=3&dtype=249&dsubtype=1&nvalue=850",
+		"hid=1&did=2006&dunit=5&dtype=243&dsubtype=22&nvalue=1&svalue=OK",
+	})
+}
+
+func Test_SensorsSettings(t *testing.T) {
+	node := &Node{Id: 4, Kind: TINYTX_TH_NODE}
+
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{TEMP_SENSOR: {Offset: -1.5}, HUMI_SENSOR: {Offset: 8}}))
+
+	// 21.3°C / 60% / 3000mV
+	assert.Nil(t, node.HandleData(node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 213, HUMI_SENSOR: 60, VCC_SENSOR: 3000})))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), float64(19.8))
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(68))
+	assert.Equal(t, node.sensorValue(VCC_SENSOR), int64(3000))
+	assert.Equal(t, node.sensorRawValue(TEMP_SENSOR), float64(21.3))
+	assert.Equal(t, node.sensorRawValue(HUMI_SENSOR), float64(60))
+
+	assert.Equal(t, node.DomoticzParams("1"), "hid=1&did=2004&dunit=1&dtype=82&dsubtype=1&nvalue=0&svalue=19.8;68;0")
+	assert.Equal(t, node.toJsonifableMap()["raw_values"], map[string]interface{}{"temperature": float64(21.3), "humidity": int64(60)})
+
+	// gain
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{HUMI_SENSOR: {Offset: 2, Gain: 1.1}}))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), float64(21.3))
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(68))
+	assert.Equal(t, node.Settings[HUMI_SENSOR], &SensorSettings{Offset: 2, Gain: 1.1})
+
+	// default settings are ignored
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{TEMP_SENSOR: {Offset: 0, Gain: 1}, HUMI_SENSOR: nil}))
+	assert.Equal(t, len(node.Settings), 0)
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(60))
+	assert.Nil(t, node.toJsonifableMap()["raw_values"])
+
+	// invalid settings
+	assert.NotNil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{LIGHT_SENSOR: {Offset: 2}}))
+
+	node = &Node{Id: 5, Kind: TINYTX_LEAK_NODE}
+	assert.NotNil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{LEAK_SENSOR: {Offset: 1}}))
+}
+
+func Test_DisabledSensors(t *testing.T) {
+	node := &Node{Id: 2, Kind: JEENODE_THLM_NODE}
+
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{LIGHT_SENSOR: {Disabled: true}}))
+	assert.Equal(t, node.enabledSensors(), []Sensor{TEMP_SENSOR, HUMI_SENSOR, MOTION_SENSOR, LOWBAT_SENSOR})
+	assert.True(t, node.haveSensor(LIGHT_SENSOR))
+	assert.False(t, node.sensorEnabled(LIGHT_SENSOR))
+
+	// light is still parsed, to get motion and low battery bits
+	assert.Nil(t, node.HandleData(node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 213, HUMI_SENSOR: 74, LIGHT_SENSOR: 255, MOTION_SENSOR: 1, LOWBAT_SENSOR: 0})))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), float64(21.3))
+	assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(74))
+	assert.Equal(t, node.sensorValue(MOTION_SENSOR), true)
+	assert.Equal(t, node.sensorValue(LOWBAT_SENSOR), false)
+
+	_, found := node.Values[LIGHT_SENSOR]
+	assert.False(t, found)
+
+	assert.Equal(t, node.TextData(), "temperature: 21.3 | humidity: 74 | motion: true | low_battery: false | dew_point: 16.5 | absolute_humidity: 13.8 | heat_index: 21.4")
+
+	_, found = node.toJsonifableMap()["light"]
+	assert.False(t, found)
+
+	dbQueries := updateNodeValuesQueries(node)
+	assert.Equal(t, dbQueries[1].query, "INSERT INTO node_values(node_id, sensor, value, raw_value) VALUES (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?), (?, ?, ?, ?)")
+
+	dbQuery := insertNodeLogValuesQuery(node, time.Now())
+	assert.Equal(t, dbQuery.query, "INSERT INTO node_log_values(node_log_id, node_id, at, sensor, value, raw_value) VALUES ((SELECT MAX(id) FROM node_logs WHERE node_id=?), ?, ?, ?, ?, ?), ((SELECT MAX(id) FROM node_logs WHERE node_id=?), ?, ?, ?, ?, ?), ((SELECT MAX(id) FROM node_logs WHERE node_id=?), ?, ?, ?, ?, ?), ((SELECT MAX(id) FROM node_logs WHERE node_id=?), ?, ?, ?, ?, ?), ((SELECT MAX(id) FROM node_logs WHERE node_id=?), ?, ?, ?, ?, ?), ((SELECT MAX(id) FROM node_logs WHERE node_id=?), ?, ?, ?, ?, ?), ((SELECT MAX(id) FROM node_logs WHERE node_id=?), ?, ?, ?, ?, ?)")
+
+	// domoticz
+	node = &Node{Id: 4, Kind: TINYTX_TH_NODE}
+	assert.Nil(t, node.HandleData(node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 213, HUMI_SENSOR: 74, VCC_SENSOR: 3000})))
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{TEMP_SENSOR: {Disabled: true}}))
+
+	assert.Equal(t, node.DomoticzParams("1"), "hid=1&did=2004&dunit=1&dtype=81&dsubtype=1&nvalue=0&svalue=74;0")
+
+	// alarms
+	node = &Node{Id: 5, Kind: TINYTX_LEAK_NODE}
+	assert.Nil(t, node.HandleData([]byte{113, 23}))
+	assert.Nil(t, node.setSensorsSettings(map[Sensor]*SensorSettings{LEAK_SENSOR: {Disabled: true}}))
+	assert.Equal(t, len(node.Alarms()), 0)
+	assert.Equal(t, node.DomoticzDevicesParams("1"), []string{})
+}
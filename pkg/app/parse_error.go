@@ -0,0 +1,54 @@
+package app
+
+import (
+	"fmt"
+)
+
+// Frame parsing error kinds
+type ParseErrorKind int
+
+const (
+	MALFORMED_TOKEN_ERROR ParseErrorKind = iota + 1 // Token is not a valid byte
+	RESERVED_BIT_ERROR                              // Reserved bit set in node infos byte
+	UNKNOWN_KIND_ERROR                              // Unsupported node kind
+	BAD_LENGTH_ERROR                                // Unexpected data length
+	GARBAGE_ERROR                                   // Not a data line
+	KIND_MISMATCH_ERROR                             // Node kind differs from known node kind
+)
+
+var NameForParseErrorKind map[ParseErrorKind]string
+
+// Error while parsing a frame received from a node
+type ParseError struct {
+	Kind ParseErrorKind
+	Msg  string
+}
+
+func init() {
+	NameForParseErrorKind = map[ParseErrorKind]string{
+		MALFORMED_TOKEN_ERROR: "malformed_token",
+		RESERVED_BIT_ERROR:    "reserved_bit",
+		UNKNOWN_KIND_ERROR:    "unknown_kind",
+		BAD_LENGTH_ERROR:      "bad_length",
+		GARBAGE_ERROR:         "garbage",
+		KIND_MISMATCH_ERROR:   "kind_mismatch",
+	}
+}
+
+// Instanciate a new parse error
+func newParseError(kind ParseErrorKind, format string, args ...interface{}) *ParseError {
+	return &ParseError{Kind: kind, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (err *ParseError) Error() string {
+	return err.Msg
+}
+
+// Returns parse error kind for given error, or 0 if that is not a parse error
+func parseErrorKind(err error) ParseErrorKind {
+	if parseErr, ok := err.(*ParseError); ok {
+		return parseErr.Kind
+	}
+
+	return 0
+}
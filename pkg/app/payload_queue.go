@@ -0,0 +1,126 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	PAYLOAD_MAX_LENGTH   = 66 // cf. RF12_MAXDATA
+	PAYLOAD_QUEUE_MAX    = 10 // max number of pending payloads per node
+	PAYLOAD_MAX_ATTEMPTS = 5  // number of times a payload is sent before being dropped
+)
+
+// Payloads waiting to be sent to nodes
+//
+// A payload is sent to a node after it requests an ACK, best effort (cf. Rf12demoHandler.sendPendingPayload()). As
+// delivery can't be confirmed, a payload stays pending and is sent again on each ACK request, until it has been sent
+// PAYLOAD_MAX_ATTEMPTS times.
+type PayloadQueue struct {
+	payloads map[int][]*queuedPayload
+	dropped  map[int]int
+	mutex    sync.Mutex
+}
+
+type queuedPayload struct {
+	data     []byte
+	attempts int
+}
+
+// Status of a pending payload
+type PendingPayload struct {
+	Data     []byte
+	Attempts int
+}
+
+// Instanciate a new payload queue
+func NewPayloadQueue() *PayloadQueue {
+	return &PayloadQueue{
+		payloads: make(map[int][]*queuedPayload),
+		dropped:  make(map[int]int),
+	}
+}
+
+// Queue a payload for given node
+func (queue *PayloadQueue) Push(nodeId int, data []byte) error {
+	if (nodeId < 1) || (nodeId > 30) {
+		return fmt.Errorf("Invalid node id: %d", nodeId)
+	}
+
+	if len(data) > PAYLOAD_MAX_LENGTH {
+		return fmt.Errorf("Payload too long: %d bytes / Max: %d", len(data), PAYLOAD_MAX_LENGTH)
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	if len(queue.payloads[nodeId]) >= PAYLOAD_QUEUE_MAX {
+		return fmt.Errorf("Too many pending payloads for node %d", nodeId)
+	}
+
+	queue.payloads[nodeId] = append(queue.payloads[nodeId], &queuedPayload{data: data})
+
+	return nil
+}
+
+// Returns next payload to send to given node, or nil if there is none
+func (queue *PayloadQueue) next(nodeId int) *queuedPayload {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	pending := queue.payloads[nodeId]
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return pending[0]
+}
+
+// Count a send attempt of given payload, and drop it once it has been sent PAYLOAD_MAX_ATTEMPTS times
+//
+// Returns true if payload was dropped.
+func (queue *PayloadQueue) sent(nodeId int, payload *queuedPayload) bool {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	payload.attempts++
+	if payload.attempts < PAYLOAD_MAX_ATTEMPTS {
+		return false
+	}
+
+	pending := queue.payloads[nodeId]
+	for i, queued := range pending {
+		if queued == payload {
+			queue.payloads[nodeId] = append(pending[:i:i], pending[i+1:]...)
+			queue.dropped[nodeId]++
+			break
+		}
+	}
+
+	if len(queue.payloads[nodeId]) == 0 {
+		delete(queue.payloads, nodeId)
+	}
+
+	return true
+}
+
+// Returns pending payloads for given node
+func (queue *PayloadQueue) Pending(nodeId int) []PendingPayload {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	result := make([]PendingPayload, len(queue.payloads[nodeId]))
+	for i, payload := range queue.payloads[nodeId] {
+		result[i] = PendingPayload{Data: payload.data, Attempts: payload.attempts}
+	}
+
+	return result
+}
+
+// Returns number of payloads dropped for given node, after PAYLOAD_MAX_ATTEMPTS attempts
+func (queue *PayloadQueue) Dropped(nodeId int) int {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	return queue.dropped[nodeId]
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+// sensors plausibility rules, applied to values after shift correction
+var PlausibilityRules = map[Sensor]*config.PlausibilityRule{
+	TEMP_SENSOR: {Min: floatPtr(-40), Max: floatPtr(85), MaxRate: 2},
+	HUMI_SENSOR: {Min: floatPtr(0), Max: floatPtr(100), MaxRate: 10},
+}
+
+// Set plausibility rules from configuration, by sensor name
+//
+// A rule replaces the default rule of that sensor.
+func LoadPlausibilityRules(rules map[string]*config.PlausibilityRule) error {
+	for name, rule := range rules {
+		sensor, found := sensorForName(name)
+		if !found {
+			return fmt.Errorf("Plausibility rule for unknown sensor: %s", name)
+		}
+
+		if err := validatePlausibilityRule(sensor, rule); err != nil {
+			return err
+		}
+
+		PlausibilityRules[sensor] = rule
+	}
+
+	return nil
+}
+
+// check a plausibility rule
+func validatePlausibilityRule(sensor Sensor, rule *config.PlausibilityRule) error {
+	if rule == nil {
+		return fmt.Errorf("Empty plausibility rule for sensor: %s", sensor.Name())
+	}
+
+	if SensorDefs[sensor].ValueType == BOOL_VALUE {
+		return fmt.Errorf("Plausibility rule not supported by sensor: %s", sensor.Name())
+	}
+
+	if (rule.Min != nil) && (rule.Max != nil) && (*rule.Min > *rule.Max) {
+		return fmt.Errorf("Invalid plausibility range for sensor %s: %v > %v", sensor.Name(), *rule.Min, *rule.Max)
+	}
+
+	if rule.MaxRate < 0 {
+		return fmt.Errorf("Invalid plausibility max rate for sensor %s: %v", sensor.Name(), rule.MaxRate)
+	}
+
+	return nil
+}
+
+// Suspicious value, held until confirmed by a following frame
+type heldValue struct {
+	value float64
+	at    time.Time
+}
+
+// Plausibility filtering state of a node
+type plausibilityState struct {
+	acceptedAt map[Sensor]time.Time
+	held       map[Sensor]*heldValue
+	rejected   map[Sensor]int
+}
+
+// Check that an incoming sensor value is plausible, and returns false if it must not be set
+//
+// A value out of range is rejected. A value that changes faster than allowed is held, and it is
+// accepted only if the value of the following frame is consistent with it.
+func (node *Node) checkPlausibility(sensor Sensor, value float64, at time.Time) bool {
+	rule := PlausibilityRules[sensor]
+	if rule == nil {
+		return true
+	}
+
+	state := &node.plausibility
+	if state.acceptedAt == nil {
+		state.acceptedAt = make(map[Sensor]time.Time)
+		state.held = make(map[Sensor]*heldValue)
+		state.rejected = make(map[Sensor]int)
+	}
+
+	held := state.held[sensor]
+	delete(state.held, sensor)
+
+	if ((rule.Min != nil) && (value < *rule.Min)) || ((rule.Max != nil) && (value > *rule.Max)) {
+		node.rejectValue(sensor, value, "out of range")
+
+		if held != nil {
+			node.rejectValue(sensor, held.value, "not confirmed")
+		}
+
+		return false
+	}
+
+	previous, found := node.Values[sensor]
+	previousAt, known := state.acceptedAt[sensor]
+
+	if found && known && !plausibleChange(rule, previous, previousAt, value, at) {
+		if (held != nil) && plausibleChange(rule, held.value, held.at, value, at) {
+			// change confirmed
+			state.acceptedAt[sensor] = at
+
+			return true
+		}
+
+		if held != nil {
+			node.rejectValue(sensor, held.value, "not confirmed")
+		}
+
+		node.LogWarn(fmt.Sprintf("Suspicious %s value held: %v => %v", sensor.Name(), previous, value))
+
+		state.held[sensor] = &heldValue{value: value, at: at}
+
+		return false
+	}
+
+	if held != nil {
+		node.rejectValue(sensor, held.value, "not confirmed")
+	}
+
+	state.acceptedAt[sensor] = at
+
+	return true
+}
+
+// count a rejected sensor value
+func (node *Node) rejectValue(sensor Sensor, value float64, reason string) {
+	node.LogWarn(fmt.Sprintf("Rejected %s value %v: %s", sensor.Name(), value, reason))
+
+	node.plausibility.rejected[sensor] += 1
+}
+
+// Returns number of rejected values, by sensor
+func (node *Node) RejectedValues() map[Sensor]int {
+	return node.plausibility.rejected
+}
+
+// Returns true if change between given values is allowed by rule
+func plausibleChange(rule *config.PlausibilityRule, from float64, fromAt time.Time, to float64, toAt time.Time) bool {
+	if rule.MaxRate == 0 {
+		return true
+	}
+
+	// at least one minute, so that close frames can still change a bit
+	minutes := math.Max(1, toAt.Sub(fromAt).Minutes())
+
+	return math.Abs(to-from) <= rule.MaxRate*minutes
+}
+
+// cf. http://stackoverflow.com/a/17323212
+func (state *plausibilityState) toJsonifableMap() map[string]interface{} {
+	rejected := make(map[string]int)
+	for sensor, nb := range state.rejected {
+		rejected[sensor.Name()] = nb
+	}
+
+	held := make(map[string]interface{})
+	for sensor, value := range state.held {
+		held[sensor.Name()] = sensor.typedValue(value.value)
+	}
+
+	return map[string]interface{}{
+		"rejected": rejected,
+		"held":     held,
+	}
+}
+
+// helper
+func floatPtr(value float64) *float64 {
+	return &value
+}
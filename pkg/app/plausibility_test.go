@@ -0,0 +1,78 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+func Test_Plausibility(t *testing.T) {
+	node := &Node{Id: 4, Kind: TINYTX_TH_NODE}
+
+	at := time.Now().UTC()
+
+	handle := func(temp uint64, humi uint64, minutes int) {
+		node.LastSeenAt = at.Add(time.Minute * time.Duration(minutes))
+		assert.Nil(t, node.HandleData(node.encodeData(map[Sensor]uint64{TEMP_SENSOR: temp, HUMI_SENSOR: humi, VCC_SENSOR: 3000})))
+	}
+
+	handle(213, 60, 0)
+	assert.Equal(t, node.Values[TEMP_SENSOR], 21.3)
+
+	// spike is held
+	handle(512, 60, 1)
+	assert.Equal(t, node.Values[TEMP_SENSOR], 21.3)
+	assert.Equal(t, node.plausibility.toJsonifableMap(), map[string]interface{}{
+		"rejected": map[string]int{},
+		"held":     map[string]interface{}{"temperature": 51.2},
+	})
+
+	// ... then rejected
+	handle(214, 60, 2)
+	assert.Equal(t, node.Values[TEMP_SENSOR], 21.4)
+	assert.Equal(t, node.RejectedValues(), map[Sensor]int{TEMP_SENSOR: 1})
+
+	// fast change is confirmed by following frame
+	handle(300, 60, 3)
+	assert.Equal(t, node.Values[TEMP_SENSOR], 21.4)
+
+	handle(302, 60, 4)
+	assert.Equal(t, node.Values[TEMP_SENSOR], 30.2)
+	assert.Equal(t, node.RejectedValues(), map[Sensor]int{TEMP_SENSOR: 1})
+
+	// slow change is allowed after a long interval
+	handle(250, 60, 10)
+	assert.Equal(t, node.Values[TEMP_SENSOR], float64(25))
+
+	// out of range
+	handle(250, 120, 11)
+	assert.Equal(t, node.Values[HUMI_SENSOR], float64(60))
+	assert.Equal(t, node.RejectedValues(), map[Sensor]int{TEMP_SENSOR: 1, HUMI_SENSOR: 1})
+
+	assert.Equal(t, node.toJsonifableMap()["plausibility"], map[string]interface{}{
+		"rejected": map[string]int{"temperature": 1, "humidity": 1},
+		"held":     map[string]interface{}{},
+	})
+}
+
+func Test_LoadPlausibilityRules(t *testing.T) {
+	saved := PlausibilityRules[TEMP_SENSOR]
+	defer func() { PlausibilityRules[TEMP_SENSOR] = saved }()
+
+	assert.NotNil(t, LoadPlausibilityRules(map[string]*config.PlausibilityRule{"foo": {MaxRate: 1}}))
+	assert.NotNil(t, LoadPlausibilityRules(map[string]*config.PlausibilityRule{"motion": {MaxRate: 1}}))
+	assert.NotNil(t, LoadPlausibilityRules(map[string]*config.PlausibilityRule{"temperature": {Min: floatPtr(10), Max: floatPtr(0)}}))
+	assert.NotNil(t, LoadPlausibilityRules(map[string]*config.PlausibilityRule{"temperature": {MaxRate: -1}}))
+
+	assert.Nil(t, LoadPlausibilityRules(map[string]*config.PlausibilityRule{"temperature": {Max: floatPtr(40)}}))
+	assert.Equal(t, *PlausibilityRules[TEMP_SENSOR], config.PlausibilityRule{Max: floatPtr(40)})
+
+	// rate is not checked anymore
+	node := &Node{Id: 4, Kind: TINYTX_TH_NODE}
+	assert.True(t, node.checkPlausibility(TEMP_SENSOR, 21.3, time.Now()))
+	assert.True(t, node.checkPlausibility(TEMP_SENSOR, 35.1, time.Now()))
+	assert.False(t, node.checkPlausibility(TEMP_SENSOR, 51.2, time.Now()))
+}
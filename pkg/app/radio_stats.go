@@ -0,0 +1,70 @@
+package app
+
+import (
+	"time"
+)
+
+const (
+	RADIO_STATS_HISTORY = 60 // in minutes
+)
+
+// Packets received by a gateway during one minute
+type RadioStatsMinute struct {
+	At   time.Time `json:"at"`
+	Good int       `json:"good"`
+	Bad  int       `json:"bad"` // bad CRC
+}
+
+// Radio statistics for a gateway
+type RadioStats struct {
+	GoodNb int
+	BadNb  int
+
+	// last minutes, most recent last
+	minutes []*RadioStatsMinute
+}
+
+// Record a received packet, and returns previous minute statistics if that packet starts a new minute
+func (stats *RadioStats) record(good bool, at time.Time) *RadioStatsMinute {
+	var result *RadioStatsMinute
+
+	minute := at.Truncate(time.Minute)
+
+	var current *RadioStatsMinute
+	if len(stats.minutes) > 0 {
+		current = stats.minutes[len(stats.minutes)-1]
+	}
+
+	if (current == nil) || !current.At.Equal(minute) {
+		result = current
+
+		current = &RadioStatsMinute{At: minute}
+		stats.minutes = append(stats.minutes, current)
+
+		// forget old minutes
+		for (len(stats.minutes) > 0) && (minute.Sub(stats.minutes[0].At) >= time.Minute*RADIO_STATS_HISTORY) {
+			stats.minutes = stats.minutes[1:]
+		}
+	}
+
+	if good {
+		stats.GoodNb += 1
+		current.Good += 1
+	} else {
+		stats.BadNb += 1
+		current.Bad += 1
+	}
+
+	return result
+}
+
+// Returns statistics for last minutes
+func (stats *RadioStats) history() []RadioStatsMinute {
+	result := make([]RadioStatsMinute, len(stats.minutes))
+
+	for index, minute := range stats.minutes {
+		result[index] = *minute
+	}
+
+	return result
+}
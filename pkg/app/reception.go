@@ -0,0 +1,201 @@
+package app
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	RECEPTION_INTERVALS_NB  = 20  // number of last intervals used to learn node transmit interval
+	RECEPTION_INTERVALS_MIN = 3   // minimum number of intervals to learn node transmit interval
+	RECEPTION_HISTORY       = 24  // in hours
+	RECEPTION_GAP_FACTOR    = 1.5 // an interval that much longer than transmit interval means that packets were missed
+	RECEPTION_LOG_WINDOW    = 1   // in hours, window of reception ratio stored in node logs
+)
+
+// rolling windows used to compute reception ratio
+var RECEPTION_WINDOWS = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": time.Hour * RECEPTION_HISTORY,
+}
+
+// Packets reception statistics for a node
+type ReceptionStats struct {
+	firstAt    time.Time
+	lastAt     time.Time
+	receivedNb int
+
+	// last intervals between packets
+	intervals []time.Duration
+
+	// arrival times during last RECEPTION_HISTORY hours
+	arrivals []time.Time
+
+	// sequence counter (cf. NodeKind.Sequence)
+	hasSequence        bool
+	lastSequence       byte
+	sequenceReceivedNb int
+	sequenceLostNb     int
+
+	mutex sync.RWMutex
+}
+
+// Instanciate new reception statistics
+func NewReceptionStats() *ReceptionStats {
+	return &ReceptionStats{
+		intervals: make([]time.Duration, 0),
+		arrivals:  make([]time.Time, 0),
+	}
+}
+
+// Record a packet arrival, and returns estimated number of packets missed before it
+func (stats *ReceptionStats) record(at time.Time) int {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	result := 0
+
+	if stats.receivedNb == 0 {
+		stats.firstAt = at
+	} else if at.After(stats.lastAt) {
+		interval := at.Sub(stats.lastAt)
+
+		if nominal := stats.interval(); (nominal > 0) && (float64(interval) > float64(nominal)*RECEPTION_GAP_FACTOR) {
+			result = int(math.Floor(float64(interval)/float64(nominal)+0.5)) - 1
+		}
+
+		stats.intervals = append(stats.intervals, interval)
+		if len(stats.intervals) > RECEPTION_INTERVALS_NB {
+			stats.intervals = stats.intervals[1:]
+		}
+	}
+
+	stats.lastAt = at
+	stats.receivedNb += 1
+
+	// trim history
+	stats.arrivals = append(stats.arrivals, at)
+
+	limit := at.Add(-time.Hour * RECEPTION_HISTORY)
+	for (len(stats.arrivals) > 0) && stats.arrivals[0].Before(limit) {
+		stats.arrivals = stats.arrivals[1:]
+	}
+
+	return result
+}
+
+// Record a sequence counter received from node, and returns number of lost packets before it
+func (stats *ReceptionStats) recordSequence(sequence byte) int {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	result := 0
+
+	if stats.hasSequence {
+		if sequence == stats.lastSequence {
+			// same packet
+			return 0
+		}
+
+		// counter wraps around
+		result = int(sequence-stats.lastSequence) - 1
+	}
+
+	stats.hasSequence = true
+	stats.lastSequence = sequence
+	stats.sequenceReceivedNb += 1
+	stats.sequenceLostNb += result
+
+	return result
+}
+
+// Returns learned node transmit interval, or 0 if unknown
+func (stats *ReceptionStats) Interval() time.Duration {
+	stats.mutex.RLock()
+	defer stats.mutex.RUnlock()
+
+	return stats.interval()
+}
+
+// Returns median of last intervals, so that missed packets are ignored
+func (stats *ReceptionStats) interval() time.Duration {
+	if len(stats.intervals) < RECEPTION_INTERVALS_MIN {
+		return 0
+	}
+
+	sorted := make([]float64, len(stats.intervals))
+	for index, interval := range stats.intervals {
+		sorted[index] = float64(interval)
+	}
+
+	sort.Float64s(sorted)
+
+	return time.Duration(sorted[len(sorted)/2])
+}
+
+// Returns ratio of received packets during given window before given time, and false if unknown
+func (stats *ReceptionStats) Ratio(window time.Duration, now time.Time) (float64, bool) {
+	stats.mutex.RLock()
+	defer stats.mutex.RUnlock()
+
+	nominal := stats.interval()
+	if nominal == 0 {
+		return 0, false
+	}
+
+	start := now.Add(-window)
+	if stats.firstAt.After(start) {
+		start = stats.firstAt
+	}
+
+	expected := float64(now.Sub(start)) / float64(nominal)
+	if expected < 1 {
+		return 0, false
+	}
+
+	received := 0
+	for _, at := range stats.arrivals {
+		if at.After(start) && !at.After(now) {
+			received += 1
+		}
+	}
+
+	result := math.Min(1, float64(received)/expected)
+
+	return math.Floor(result*1000+0.5) / 1000, true
+}
+
+// cf. http://stackoverflow.com/a/17323212
+func (stats *ReceptionStats) toJsonifableMap(now time.Time) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	if interval := stats.Interval(); interval > 0 {
+		result["interval"] = interval.Seconds()
+	}
+
+	ratios := make(map[string]float64)
+	for name, window := range RECEPTION_WINDOWS {
+		if ratio, ok := stats.Ratio(window, now); ok {
+			ratios[name] = ratio
+		}
+	}
+	result["ratios"] = ratios
+
+	stats.mutex.RLock()
+	defer stats.mutex.RUnlock()
+
+	result["received_nb"] = stats.receivedNb
+
+	if len(stats.intervals) > 0 {
+		result["last_interval"] = stats.intervals[len(stats.intervals)-1].Seconds()
+	}
+
+	if stats.hasSequence {
+		result["sequence_lost_nb"] = stats.sequenceLostNb
+		result["sequence_ratio"] = math.Floor(float64(stats.sequenceReceivedNb)/float64(stats.sequenceReceivedNb+stats.sequenceLostNb)*1000+0.5) / 1000
+	}
+
+	return result
+}
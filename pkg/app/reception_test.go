@@ -0,0 +1,110 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ReceptionStats(t *testing.T) {
+	stats := NewReceptionStats()
+
+	startAt := time.Date(2014, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	_, ok := stats.Ratio(time.Hour, startAt)
+	assert.False(t, ok)
+
+	// one packet per minute
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, stats.record(startAt.Add(time.Minute*time.Duration(i))), 0)
+	}
+
+	assert.Equal(t, stats.Interval(), time.Minute)
+
+	ratio, ok := stats.Ratio(time.Hour, startAt.Add(time.Minute*9))
+	assert.True(t, ok)
+	assert.Equal(t, ratio, float64(1))
+
+	// two packets missed
+	assert.Equal(t, stats.record(startAt.Add(time.Minute*12)), 2)
+	assert.Equal(t, stats.Interval(), time.Minute)
+
+	ratio, _ = stats.Ratio(time.Hour, startAt.Add(time.Minute*12))
+	assert.Equal(t, ratio, float64(0.833))
+
+	// node is silent
+	ratio, _ = stats.Ratio(time.Hour, startAt.Add(time.Minute*60))
+	assert.Equal(t, ratio, float64(0.167))
+
+	ratio, _ = stats.Ratio(time.Hour, startAt.Add(time.Hour*3))
+	assert.Equal(t, ratio, float64(0))
+
+	result := stats.toJsonifableMap(startAt.Add(time.Minute * 12))
+	assert.Equal(t, result["interval"], float64(60))
+	assert.Equal(t, result["last_interval"], float64(180))
+	assert.Equal(t, result["received_nb"], 11)
+	assert.Equal(t, result["ratios"], map[string]float64{"1h": 0.833, "24h": 0.833})
+	assert.Nil(t, result["sequence_ratio"])
+}
+
+func Test_ReceptionSequence(t *testing.T) {
+	stats := NewReceptionStats()
+
+	assert.Equal(t, stats.recordSequence(250), 0)
+	assert.Equal(t, stats.recordSequence(251), 0)
+	assert.Equal(t, stats.recordSequence(254), 2)
+
+	// counter wraps around
+	assert.Equal(t, stats.recordSequence(255), 0)
+	assert.Equal(t, stats.recordSequence(0), 0)
+	assert.Equal(t, stats.recordSequence(3), 2)
+
+	// duplicate
+	assert.Equal(t, stats.recordSequence(3), 0)
+
+	result := stats.toJsonifableMap(time.Now())
+	assert.Equal(t, result["sequence_lost_nb"], 4)
+	assert.Equal(t, result["sequence_ratio"], float64(0.6))
+}
+
+func Test_NodeKindSequence(t *testing.T) {
+	kinds, err := parseNodeKinds(strings.NewReader(`[{"kind": 100, "name": "Sequenced node", "sequence": true, "fields": [{"sensor": "temperature", "bits": 10, "signed": true, "scale": 0.1}, {"sensor": "vcc", "bits": 12}]}]`))
+	assert.Nil(t, err)
+
+	NodeKinds[100] = kinds[0]
+	defer delete(NodeKinds, 100)
+
+	node := &Node{Id: 9, Kind: 100}
+	assert.Equal(t, node.expectedDataLength(), 4)
+
+	data := node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 213, VCC_SENSOR: 3000})
+	data[3] = 42
+
+	assert.Nil(t, node.HandleData(data))
+	assert.Equal(t, node.sensorValue(TEMP_SENSOR), float64(21.3))
+	assert.Equal(t, node.sensorValue(VCC_SENSOR), int64(3000))
+
+	sequence, ok := node.sequence(data)
+	assert.True(t, ok)
+	assert.Equal(t, sequence, byte(42))
+
+	at := time.Date(2014, 5, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		data[3] = byte(42 + 2*i)
+		node.recordReception(data, at.Add(time.Minute*time.Duration(2*i)))
+	}
+
+	assert.Equal(t, node.Reception.sequenceLostNb, 4)
+	assert.Equal(t, node.toJsonifableMap()["reception"].(map[string]interface{})["sequence_ratio"], float64(0.556))
+
+	dbQuery := insertNodeLogQuery(node, at.Add(time.Minute*8))
+	assert.Equal(t, dbQuery.query, "INSERT INTO node_logs(node_id, at, reception) VALUES(?, ?, ?)")
+	assert.Equal(t, dbQuery.args[2], float64(1))
+
+	// no sequence
+	node = &Node{Id: 3, Kind: TINYTX_T_NODE}
+	_, ok = node.sequence([]byte{1, 2, 3})
+	assert.False(t, ok)
+}
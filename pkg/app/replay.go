@@ -0,0 +1,119 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	log "code.google.com/p/log4go"
+)
+
+// Replay a RF12demo log file (cf. rf12demo_log_file setting)
+//
+// Each line is expected to be formatted like that:
+//
+//	[2014-05-01T10:04:32Z] OK 2 3 156 149 213 0
+//
+// Recorded timestamps are used as nodes last seen time, and node logs are inserted
+// every LOG_PERIOD minutes of recorded time. If realtime is true, lines are replayed
+// at recorded speed, otherwise as fast as possible.
+func (jeego *Jeego) Replay(filePath string, realtime bool) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	log.Info("Replaying RF12demo log file: %s", filePath)
+
+	var lastAt time.Time
+	var nextLogAt time.Time
+
+	// nodes seen during replay
+	nodes := make(map[int]*Node)
+
+	nbLines := 0
+	nbReplayed := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		nbLines += 1
+
+		line := strings.Trim(scanner.Text(), " \n\r")
+		if line == "" {
+			continue
+		}
+
+		at, data, err := parseReplayLine(line)
+		if err != nil {
+			log.Warn("Skipping line %d: %s", nbLines, err)
+			continue
+		}
+
+		dataLog, err := parseLine(data)
+		if err != nil {
+			log.Warn("Skipping line %d: %s", nbLines, err)
+			continue
+		}
+
+		dataLog.at = at
+
+		if lastAt.IsZero() {
+			nextLogAt = at.Add(time.Minute * LOG_PERIOD)
+		} else if realtime && at.After(lastAt) {
+			time.Sleep(at.Sub(lastAt))
+		}
+
+		// insert node logs, as if nodes logs ticker was running
+		for nextLogAt.Before(at) {
+			for _, node := range nodes {
+				jeego.Database.insertNodeLog(node, nextLogAt)
+			}
+
+			nextLogAt = nextLogAt.Add(time.Minute * LOG_PERIOD)
+		}
+
+		node, err := jeego.handleDataLog(dataLog)
+		if node != nil {
+			nodes[node.Id] = node
+		}
+
+		if err != nil {
+			log.Warn("Rejected line %d: %s", nbLines, err)
+			continue
+		}
+
+		lastAt = at
+		nbReplayed += 1
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// aggregate replayed logs
+	jeego.Database.updateRollups()
+
+	log.Info("Replayed %d lines (%d lines read) for %d nodes", nbReplayed, nbLines, len(nodes))
+
+	return nil
+}
+
+// Parse a line from RF12demo log file, and returns timestamp and RF12demo data
+func parseReplayLine(line string) (time.Time, string, error) {
+	var at time.Time
+
+	endIndex := strings.Index(line, "]")
+	if !strings.HasPrefix(line, "[") || (endIndex == -1) {
+		return at, "", fmt.Errorf("Missing timestamp: %s", line)
+	}
+
+	at, err := time.Parse(time.RFC3339, line[1:endIndex])
+	if err != nil {
+		return at, "", err
+	}
+
+	return at.UTC(), strings.TrimSpace(line[endIndex+1:]), nil
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseReplayLine(t *testing.T) {
+	at, data, err := parseReplayLine("[2014-05-01T10:04:32Z] OK 2 3 156 149 213 0")
+	assert.Nil(t, err)
+	assert.Equal(t, at, time.Date(2014, 5, 1, 10, 4, 32, 0, time.UTC))
+	assert.Equal(t, data, "OK 2 3 156 149 213 0")
+
+	_, _, err = parseReplayLine("OK 2 3 156 149 213 0")
+	assert.NotNil(t, err)
+
+	_, _, err = parseReplayLine("[yesterday] OK 2 3 156 149 213 0")
+	assert.NotNil(t, err)
+}
+
+func Test_Replay(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Database: db}
+
+	file, err := ioutil.TempFile("", "jeego-test-replay")
+	if err != nil {
+		t.Fatal("Failed to create replay file:", err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("[2014-05-01T10:00:00Z] OK 2 1 213 40 57 3\n")
+	file.WriteString("garbage\n")
+	file.WriteString("[2014-05-01T10:07:00Z] OK 3 3 18 113 49\n")
+	file.WriteString("[2014-05-01T10:11:00Z] OK 2 1 210 200 112 1\n")
+	file.Close()
+
+	err = jeego.Replay(file.Name(), false)
+	assert.Nil(t, err)
+
+	assert.Equal(t, len(db.nodes), 2)
+
+	node2 := db.NodeForId(2)
+	assert.Equal(t, node2.LastSeenAt, time.Date(2014, 5, 1, 10, 11, 0, 0, time.UTC))
+	assert.Equal(t, node2.sensorValue(TEMP_SENSOR), float64(21.0))
+
+	node3 := db.NodeForId(3)
+	assert.Equal(t, node3.LastSeenAt, time.Date(2014, 5, 1, 10, 7, 0, 0, time.UTC))
+	assert.Equal(t, node3.sensorValue(TEMP_SENSOR), float64(27.4))
+
+	// logs inserted at 10:05 and 10:10
+	nodeLogs := db.nodeLogs(node2)
+	assert.Equal(t, len(nodeLogs), 2)
+	assert.Equal(t, nodeLogs[0].At.UTC(), time.Date(2014, 5, 1, 10, 5, 0, 0, time.UTC))
+	assert.Equal(t, nodeLogs[0].Values[TEMP_SENSOR], float64(21.3))
+	assert.Equal(t, nodeLogs[1].At.UTC(), time.Date(2014, 5, 1, 10, 10, 0, 0, time.UTC))
+	assert.Equal(t, nodeLogs[1].Values[TEMP_SENSOR], float64(21.3))
+
+	// node 3 was only seen at 10:07
+	nodeLogs = db.nodeLogs(node3)
+	assert.Equal(t, len(nodeLogs), 1)
+	assert.Equal(t, nodeLogs[0].At.UTC(), time.Date(2014, 5, 1, 10, 10, 0, 0, time.UTC))
+}
@@ -1,8 +1,9 @@
 package app
 
 import (
-	"errors"
+	"encoding/hex"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -10,92 +11,322 @@ import (
 	log "code.google.com/p/log4go"
 )
 
+const (
+	DEDUP_WINDOW = 2000 // in milliseconds
+)
+
+// Line received from a gateway
+type Rf12demoLine struct {
+	gateway *Gateway
+	text    string
+	at      time.Time
+}
+
+// Rf12demo sketch status, as reported by banner and configuration lines
+type Rf12demoStatus struct {
+	Version    int  // firmware version, 0 if not reported
+	Configured bool // true if configuration is reported
+	NodeId     int
+	Group      int
+	Band       int // in MHz
+	Quiet      bool
+	Collect    bool
+}
+
+var rf12demoBannerRegexp = regexp.MustCompile(`\[RF12demo\.(\d+)\]`)
+var rf12demoConfigRegexp = regexp.MustCompile(`\bi(\d+)\*?\s+g(\d+)\s+@\s+(\d+)\s+MHz`)
+var rf12demoQuietRegexp = regexp.MustCompile(`\bq(\d)\b`)
+var rf12demoCollectRegexp = regexp.MustCompile(`\bc(\d)\b`)
+
+// signal strength, eg: (-72)
+var rssiRegexp = regexp.MustCompile(`^\((-?\d+)\)$`)
+
 // Rf12demo Data Log
 type Rf12demoDataLog struct {
 	nodeId   int
 	nodeKind int
 	data     []byte
 	at       time.Time
+	gateway  *Gateway
+	rssi     int // in dBm
+	hasRssi  bool
+
+	// header flags
+	ctl bool // control packet (ie. an ACK)
+	dst bool // node id is the destination, not the source
+	ack bool // ACK requested
+}
+
+// RF12demo handler
+type Rf12demoHandler struct {
+	jeego          *Jeego
+	dedup          *Deduplicator
+	loggerChan     chan string
+	quarantineChan chan string
 }
 
 // Start RF12demo handler
-func RunRf12demo(jeego *Jeego) chan string {
-	inputChan := make(chan string, 1)
+func RunRf12demo(jeego *Jeego) chan *Rf12demoLine {
+	inputChan := make(chan *Rf12demoLine, 1)
 
 	go func() {
-		var line string
-		var loggerChan chan string
+		handler := newRf12demoHandler(jeego)
 
 		if jeego.Config.Rf12demoLogFile != "" {
-			loggerChan = runRf12demoLogger(jeego)
+			log.Info("Logging RF12demo data to file: %s", jeego.Config.Rf12demoLogFile)
+
+			handler.loggerChan = runRawLogger(jeego.Config.Rf12demoLogFile)
+		}
+
+		if jeego.Config.Rf12demoQuarantineFile != "" {
+			log.Info("Logging rejected RF12demo data to file: %s", jeego.Config.Rf12demoQuarantineFile)
+
+			handler.quarantineChan = runRawLogger(jeego.Config.Rf12demoQuarantineFile)
 		}
 
 		// loop forever
 		for {
-			line = <-inputChan
+			handler.handleLine(<-inputChan)
+		}
+	}()
 
-			// parse node infos and data
-			dataLog, err := parseLine(line)
-			if err == nil {
-				if loggerChan != nil {
-					// log raw data log
-					loggerChan <- fmt.Sprintf("[%s] %s", dataLog.at.Format(time.RFC3339), line)
-				}
+	return inputChan
+}
 
-				// get node
-				node := jeego.Database.NodeForId(dataLog.nodeId)
-				if node == nil {
-					// insert new node in database
-					node = jeego.Database.InsertNode(dataLog.nodeId, dataLog.nodeKind)
+// Instanciate a new RF12demo handler
+func newRf12demoHandler(jeego *Jeego) *Rf12demoHandler {
+	dedupWindow := jeego.Config.DedupWindow
+	if dedupWindow <= 0 {
+		dedupWindow = DEDUP_WINDOW
+	}
 
-					// debug
-					node.LogDebug("Added to database")
-				} else if node.Kind != dataLog.nodeKind {
-					// debug
-					node.LogDebug(fmt.Sprintf("Kind changed from %d to %d", node.Kind, dataLog.nodeKind))
+	return &Rf12demoHandler{
+		jeego: jeego,
+		dedup: NewDeduplicator(time.Millisecond * time.Duration(dedupWindow)),
+	}
+}
 
-					node.Kind = dataLog.nodeKind
+// Handle a line received from a gateway
+func (handler *Rf12demoHandler) handleLine(line *Rf12demoLine) {
+	if isBadCrcLine(line.text) {
+		log.Debug("Bad CRC packet: %s", line.text)
 
-					// reset sensors values
-					node.ResetSensors()
-				}
+		if line.gateway != nil {
+			line.gateway.packetReceived(false, line.at)
+		}
 
-				node.LastSeenAt = time.Now().UTC()
+		return
+	}
 
-				// handle data
-				node.HandleData(dataLog.data)
+	if isDataLine(line.text) && (line.gateway != nil) {
+		line.gateway.packetReceived(true, line.at)
+	}
+
+	// parse node infos and data
+	dataLog, err := parseLine(line.text)
+	if (err == nil) && (dataLog.ctl || dataLog.dst) {
+		// sender is unknown
+		log.Debug("Packet not sent by a node ignored: %s", line.text)
+	} else if err == nil {
+		dataLog.at = line.at
+		dataLog.gateway = line.gateway
 
-				// debug
-				node.LogDebug(node.TextData())
+		duplicate := handler.dedup.isDuplicate(dataLog)
 
-				// update database
-				jeego.Database.UpdateNode(node)
+		if line.gateway != nil {
+			line.gateway.nodeHeard(dataLog, !duplicate)
+		}
 
-				// @todo send to websocket clients right now, instead of in runNodeLogsTicker()
-				//       ... so get rid of the runNodeLogsTicker() mecanism please
-				// jeego.WsHub.SendMsg([]byte(node.TextData()))
+		if duplicate {
+			log.Debug("Duplicate packet ignored: %s", line.text)
+			return
+		}
 
-				// push to domoticz
-				if jeego.Domoticz != nil {
-					go jeego.Domoticz.Push(node.DomoticzParams(jeego.Domoticz.HardwareId))
-				}
+		// handle node data
+		_, err = handler.jeego.handleDataLog(dataLog)
+		if err == nil {
+			// a rejected frame may have been sent by another node, that must not get the payload
+			if dataLog.ack && (line.gateway != nil) {
+				handler.sendPendingPayload(line.gateway, dataLog.nodeId)
+			}
 
-				// @todo insert in InfluxDB
+			if handler.loggerChan != nil {
+				// log raw data log
+				handler.loggerChan <- fmt.Sprintf("[%s] %s", dataLog.at.Format(time.RFC3339), line.text)
 			}
+		} else {
+			handler.reject(line, err)
+		}
+	} else if status, ok := parseStatusLine(line.text); ok {
+		log.Info("RF12demo status: %s", line.text)
+
+		if line.gateway != nil {
+			line.gateway.statusReceived(status, line.at)
+		}
+	} else if parseErrorKind(err) != GARBAGE_ERROR {
+		handler.reject(line, err)
+	} else {
+		log.Debug("Garbage received: %s", line.text)
+	}
+}
+
+// Send next pending payload to a node that requested an ACK, ie. that may still be listening
+//
+// RF12demo sketch can't put a payload in an ACK, and a reply sent through the serial port arrives well after
+// the ACK window of a stock JeeLib node. So the payload is sent as a plain data packet, after the empty ACK sent
+// by the sketch, and it is only received by nodes that keep listening after an ACK. As delivery is not confirmed,
+// the payload stays pending and is sent again on next ACK requests, until it is dropped (cf. PayloadQueue).
+func (handler *Rf12demoHandler) sendPendingPayload(gateway *Gateway, nodeId int) {
+	if handler.jeego.Payloads == nil {
+		return
+	}
+
+	payload := handler.jeego.Payloads.next(nodeId)
+	if payload == nil {
+		return
+	}
+
+	if gateway.collectMode() {
+		log.Debug("Gateway %s: ACK requested by node %d is not sent in collect mode, sending pending payload anyway", gateway.Name, nodeId)
+	}
+
+	go func() {
+		if err := gateway.SendPacket(nodeId, payload.data); err != nil {
+			log.Error("Gateway %s: failed to send payload to node %d: %s", gateway.Name, nodeId, err)
+		} else if handler.jeego.Payloads.sent(nodeId, payload) {
+			log.Warn("Gateway %s: dropped payload %v to node %d, after %d attempts", gateway.Name, payload.data, nodeId, PAYLOAD_MAX_ATTEMPTS)
+		} else {
+			log.Info("Gateway %s: sent payload %v to node %d", gateway.Name, payload.data, nodeId)
 		}
 	}()
+}
 
-	return inputChan
+// Reject an invalid data line
+func (handler *Rf12demoHandler) reject(line *Rf12demoLine, err error) {
+	log.Warn("Rejected line %q: %s", line.text, err)
+
+	if line.gateway != nil {
+		line.gateway.parseErrorReceived(parseErrorKind(err))
+	}
+
+	if handler.quarantineChan != nil {
+		handler.quarantineChan <- fmt.Sprintf("[%s] %s", line.at.Format(time.RFC3339), line.text)
+	}
+}
+
+// Handle data received from a node, and returns that node
+func (jeego *Jeego) handleDataLog(dataLog *Rf12demoDataLog) (*Node, error) {
+	// check data before touching node
+	if err := (&Node{Kind: dataLog.nodeKind}).checkData(dataLog.data); err != nil {
+		return nil, err
+	}
+
+	// get node
+	node := jeego.Database.NodeForId(dataLog.nodeId)
+	if node == nil {
+		// insert new node in database
+		node = jeego.Database.InsertNode(dataLog.nodeId, dataLog.nodeKind)
+
+		// debug
+		node.LogDebug("Added to database")
+	} else if change, err := node.checkKind(dataLog.nodeKind, dataLog.at); err != nil {
+		return node, err
+	} else if change != nil {
+		node.LogWarn(fmt.Sprintf("Kind changed from %d to %d", change.FromKind, change.ToKind))
+
+		jeego.Database.insertNodeKindChange(change)
+
+		// reset sensors values
+		node.ResetSensors()
+	}
+
+	node.LastSeenAt = dataLog.at
+
+	node.recordReception(dataLog.data, dataLog.at)
+
+	if dataLog.hasRssi {
+		node.recordRssi(dataLog.rssi)
+	}
+
+	// keep alarms state
+	alarms := make(map[Sensor]float64)
+	for _, sensor := range node.enabledSensors() {
+		if sensor.Critical() {
+			alarms[sensor] = node.Values[sensor]
+		}
+	}
+
+	// keep discrete sensors state
+	discrete := node.discreteValues()
+
+	// keep battery state
+	previousVcc := node.Values[VCC_SENSOR]
+	previousLow := node.lowBattery()
+
+	// handle data
+	if err := node.HandleData(dataLog.data); err != nil {
+		return node, err
+	}
+
+	// debug
+	node.LogDebug(node.TextData())
+
+	// update database
+	jeego.Database.UpdateNode(node)
+
+	// @todo send to websocket clients right now, instead of in runNodeLogsTicker()
+	//       ... so get rid of the runNodeLogsTicker() mecanism please
+	// jeego.WsHub.SendMsg([]byte(node.TextData()))
+
+	// push to domoticz
+	if jeego.Domoticz != nil {
+		for _, params := range node.DomoticzDevicesParams(jeego.Domoticz.HardwareId) {
+			go jeego.Domoticz.Push(params)
+		}
+	}
+
+	// handle alarms right now, instead of waiting for runNodeLogsTicker()
+	for sensor, previous := range alarms {
+		if node.Values[sensor] != previous {
+			jeego.alarmChanged(node, sensor, dataLog.at)
+		}
+	}
+
+	// record discrete sensors events with exact time, instead of waiting for runNodeLogsTicker()
+	for _, event := range node.sensorEvents(discrete, dataLog.at) {
+		jeego.Database.insertSensorEvent(event)
+	}
+
+	jeego.checkBattery(node, previousVcc, previousLow, dataLog.at)
+
+	// @todo insert in InfluxDB
+
+	return node, nil
+}
+
+// Log and broadcast an alarm state change
+func (jeego *Jeego) alarmChanged(node *Node, sensor Sensor, at time.Time) {
+	if node.Values[sensor] != 0 {
+		node.LogWarn(fmt.Sprintf("Alarm raised: %s", sensor.Name()))
+	} else {
+		node.LogWarn(fmt.Sprintf("Alarm cleared: %s", sensor.Name()))
+	}
+
+	jeego.Database.insertNodeLog(node, at)
+
+	if jeego.WsHub != nil {
+		jeego.WsHub.SendMsg([]byte(node.TextData()))
+	}
 }
 
-// Start RF12demo logger
-func runRf12demoLogger(jeego *Jeego) chan string {
+// Start a logger that writes raw lines to given file
+func runRawLogger(filePath string) chan string {
 	inputChan := make(chan string, 1)
 
 	go func() {
 		var line string
 
-		flw := log.NewFileLogWriter(jeego.Config.Rf12demoLogFile, false)
+		flw := log.NewFileLogWriter(filePath, false)
 		flw.SetFormat("%M")
 		flw.SetRotate(true)
 		flw.SetRotateSize(0)
@@ -105,8 +336,6 @@ func runRf12demoLogger(jeego *Jeego) chan string {
 		rawLogger := log.NewDefaultLogger(log.DEBUG)
 		rawLogger.AddFilter("file", log.INFO, flw)
 
-		log.Info("Logging RF12demo data to file: %s", jeego.Config.Rf12demoLogFile)
-
 		// loop forever
 		for {
 			line = <-inputChan
@@ -136,56 +365,163 @@ func runRf12demoLogger(jeego *Jeego) chan string {
 //     CTL DST ACK         ^
 //                    node id => 2
 //
+// CTL is set for control packets (ie. ACKs), DST is set when node id is the destination
+// instead of the source, and ACK is set when sender requests an ACK.
+//
+// Control packets don't have a node kind byte.
+//
 // node kind:
 //
 //      0   0   0   0   0   0   1   1
 //      ^   -------------------------
 // reserved            ^
 //               node kind => 3
+//
+// RFM69 based gateways may append signal strength to the line:
+//
+//       OK 2 3 156 149 213 0 (-72)
+//
+// Data can also be received in hexadecimal form:
+//
+//       OKX 02039C95D500
 func parseLine(line string) (dataLog *Rf12demoDataLog, err error) {
 	// split line
-	dataStrArray := strings.Split(line, " ")
+	dataStrArray := strings.Fields(line)
+
+	// parse signal strength
+	rssi, hasRssi := 0, false
+	if len(dataStrArray) > 0 {
+		if matches := rssiRegexp.FindStringSubmatch(dataStrArray[len(dataStrArray)-1]); matches != nil {
+			rssi, _ = strconv.Atoi(matches[1])
+			hasRssi = true
+
+			dataStrArray = dataStrArray[:len(dataStrArray)-1]
+		}
+	}
 
 	// parse status
-	if (len(dataStrArray) > 3) && (dataStrArray[0] == "OK") {
+	var packet []byte
+	if (len(dataStrArray) > 1) && (dataStrArray[0] == "OK") {
+		packet = make([]byte, len(dataStrArray)-1)
+
+		for index, dataStr := range dataStrArray[1:] {
+			packet[index], err = byteFromString(dataStr)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else if (len(dataStrArray) > 1) && (dataStrArray[0] == "OKX") {
+		packet, err = hex.DecodeString(strings.Join(dataStrArray[1:], ""))
+		if err != nil {
+			return nil, newParseError(MALFORMED_TOKEN_ERROR, "Malformed hexadecimal data: %s", err)
+		}
+	}
+
+	if (len(packet) > 0) && ((packet[0] & 0x80) != 0) {
+		// control packet
+		dataLog = &Rf12demoDataLog{at: time.Now().UTC(), rssi: rssi, hasRssi: hasRssi}
+		dataLog.parseHeader(packet[0])
+		dataLog.data = packet[1:]
+	} else if (len(packet) > 1) && (NodeDecoders[int(packet[0]&0x1f)] != 0) {
+		// node does not send a node kind byte
+		dataLog = &Rf12demoDataLog{at: time.Now().UTC(), rssi: rssi, hasRssi: hasRssi}
+
+		// parse header
+		dataLog.parseHeader(packet[0])
+
+		// node kind of payload format
+		dataLog.nodeKind = NodeDecoders[dataLog.nodeId]
+
+		// parse data
+		dataLog.data = packet[1:]
+	} else if len(packet) > 2 {
 		// parse node infos
-		nodeInfosByte := byteFromString(dataStrArray[2])
+		nodeInfosByte := packet[1]
 
 		// check reserved field
 		if (nodeInfosByte & 0x80) != 0 {
-			err = errors.New("Received payload with reserved field set to 1")
-			log.Error(err)
+			err = newParseError(RESERVED_BIT_ERROR, "Received payload with reserved field set to 1")
 		} else {
-			dataLog = &Rf12demoDataLog{at: time.Now().UTC()}
+			dataLog = &Rf12demoDataLog{at: time.Now().UTC(), rssi: rssi, hasRssi: hasRssi}
 
-			// parse node id
-			dataLog.nodeId = int(byteFromString(dataStrArray[1]) & 0x1f)
+			// parse header
+			dataLog.parseHeader(packet[0])
 
 			// parse node kind
 			dataLog.nodeKind = int(nodeInfosByte & 0x7f)
 
 			// parse data
-			dataLog.data = make([]byte, len(dataStrArray)-3)
-
-			for index, dataStr := range dataStrArray {
-				if index > 2 {
-					dataLog.data[index-3] = byteFromString(dataStr)
-				}
-			}
+			dataLog.data = packet[2:]
 		}
+	} else if isDataLine(line) {
+		err = newParseError(BAD_LENGTH_ERROR, "Packet too short")
 	} else {
-		err = errors.New("Garbage received")
+		err = newParseError(GARBAGE_ERROR, "Garbage received")
 	}
 
 	return
 }
 
+// Parse header byte
+func (dataLog *Rf12demoDataLog) parseHeader(header byte) {
+	dataLog.ctl = (header & 0x80) != 0
+	dataLog.dst = (header & 0x40) != 0
+	dataLog.ack = (header & 0x20) != 0
+	dataLog.nodeId = int(header & 0x1f)
+}
+
+// Returns true if line is a packet received with a good CRC
+func isDataLine(line string) bool {
+	return strings.HasPrefix(line, "OK ") || strings.HasPrefix(line, "OKX ")
+}
+
+// Returns true if line is a packet received with a bad CRC
+//
+// Example (RF12demo sketch not in quiet mode):
+//
+//	? 12 34 56 78
+func isBadCrcLine(line string) bool {
+	return (line == "?") || strings.HasPrefix(line, "? ")
+}
+
+// Parse a banner or configuration line sent by RF12demo sketch
+//
+// Examples:
+//
+//	[RF12demo.12] _ i31* g212 @ 868 MHz c1 q1
+//	A i31* g212 @ 868 MHz
+func parseStatusLine(line string) (*Rf12demoStatus, bool) {
+	result := &Rf12demoStatus{}
+
+	if matches := rf12demoBannerRegexp.FindStringSubmatch(line); matches != nil {
+		result.Version, _ = strconv.Atoi(matches[1])
+	}
+
+	if matches := rf12demoConfigRegexp.FindStringSubmatch(line); matches != nil {
+		result.Configured = true
+		result.NodeId, _ = strconv.Atoi(matches[1])
+		result.Group, _ = strconv.Atoi(matches[2])
+		result.Band, _ = strconv.Atoi(matches[3])
+
+		// flags are only reported when set
+		if matches := rf12demoQuietRegexp.FindStringSubmatch(line); matches != nil {
+			result.Quiet = (matches[1] != "0")
+		}
+
+		if matches := rf12demoCollectRegexp.FindStringSubmatch(line); matches != nil {
+			result.Collect = (matches[1] != "0")
+		}
+	}
+
+	return result, (result.Version != 0) || result.Configured
+}
+
 // helper
-func byteFromString(val string) byte {
+func byteFromString(val string) (byte, error) {
 	i, err := strconv.ParseUint(val, 10, 8)
 	if err != nil {
-		panic(log.Critical(err))
+		return 0, newParseError(MALFORMED_TOKEN_ERROR, "Malformed token: %q", val)
 	}
 
-	return byte(i)
+	return byte(i), nil
 }
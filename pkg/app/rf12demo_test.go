@@ -0,0 +1,451 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aymerick/jeego/pkg/config"
+	"github.com/aymerick/jeego/pkg/serial_reader"
+)
+
+func Test_Deduplicator(t *testing.T) {
+	dedup := NewDeduplicator(time.Second * 2)
+
+	at := time.Now().UTC()
+
+	dataLog := &Rf12demoDataLog{nodeId: 2, nodeKind: JEENODE_THLM_NODE, data: []byte{213, 40, 57, 3}, at: at}
+	assert.False(t, dedup.isDuplicate(dataLog))
+
+	// same packet received by another gateway
+	dataLog = &Rf12demoDataLog{nodeId: 2, nodeKind: JEENODE_THLM_NODE, data: []byte{213, 40, 57, 3}, at: at.Add(time.Millisecond * 30)}
+	assert.True(t, dedup.isDuplicate(dataLog))
+
+	// another packet
+	dataLog = &Rf12demoDataLog{nodeId: 2, nodeKind: JEENODE_THLM_NODE, data: []byte{210, 40, 57, 3}, at: at.Add(time.Millisecond * 50)}
+	assert.False(t, dedup.isDuplicate(dataLog))
+
+	// same packet, later
+	dataLog = &Rf12demoDataLog{nodeId: 2, nodeKind: JEENODE_THLM_NODE, data: []byte{213, 40, 57, 3}, at: at.Add(time.Minute)}
+	assert.False(t, dedup.isDuplicate(dataLog))
+}
+
+func Test_HandleLineFromSeveralGateways(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Config: &config.Config{}, Database: db}
+	handler := newRf12demoHandler(jeego)
+
+	ground := NewGateway("ground", serial_reader.NewTransport("/dev/ttyUSB0", 57600), nil)
+	first := NewGateway("first", serial_reader.NewTransport("/dev/ttyUSB1", 57600), nil)
+
+	at := time.Now().UTC()
+
+	handler.handleLine(&Rf12demoLine{gateway: ground, text: "OK 2 1 213 40 57 3 (-90)", at: at})
+	handler.handleLine(&Rf12demoLine{gateway: first, text: "OK 2 1 213 40 57 3 (-60)", at: at.Add(time.Millisecond * 20)})
+	handler.handleLine(&Rf12demoLine{gateway: first, text: "OK 3 3 18 113 49", at: at.Add(time.Millisecond * 40)})
+
+	assert.Equal(t, len(db.nodes), 2)
+	assert.Equal(t, db.NodeForId(2).LastSeenAt, at)
+
+	groundStats := ground.NodesStats()
+	assert.Equal(t, len(groundStats), 1)
+	assert.Equal(t, groundStats[0], GatewayNodeStats{NodeId: 2, FramesNb: 1, FirstNb: 1, LastSeenAt: at, Rssi: -90, RssiMin: -90, RssiAvg: -90})
+
+	firstStats := first.NodesStats()
+	assert.Equal(t, len(firstStats), 2)
+	// signal strength of duplicate frame is recorded too
+	assert.Equal(t, firstStats[0], GatewayNodeStats{NodeId: 2, FramesNb: 1, FirstNb: 0, LastSeenAt: at.Add(time.Millisecond * 20), Rssi: -60, RssiMin: -60, RssiAvg: -60})
+	assert.Equal(t, firstStats[1], GatewayNodeStats{NodeId: 3, FramesNb: 1, FirstNb: 1, LastSeenAt: at.Add(time.Millisecond * 40)})
+}
+
+func Test_ParseStatusLine(t *testing.T) {
+	status, ok := parseStatusLine("[RF12demo.12] _ i31* g212 @ 868 MHz c1 q1")
+	assert.True(t, ok)
+	assert.Equal(t, *status, Rf12demoStatus{Version: 12, Configured: true, NodeId: 31, Group: 212, Band: 868, Quiet: true, Collect: true})
+
+	status, ok = parseStatusLine("A i1* g212 @ 433 MHz")
+	assert.True(t, ok)
+	assert.Equal(t, *status, Rf12demoStatus{Configured: true, NodeId: 1, Group: 212, Band: 433})
+
+	status, ok = parseStatusLine("[RF12demo.10]")
+	assert.True(t, ok)
+	assert.Equal(t, *status, Rf12demoStatus{Version: 10})
+
+	_, ok = parseStatusLine("Available commands:")
+	assert.False(t, ok)
+}
+
+func Test_GatewayCheckConfig(t *testing.T) {
+	conf := &config.Rf12demoConfig{Band: 8, Group: 212, NodeId: 31, Quiet: true}
+	gateway := NewGateway("default", serial_reader.NewTransport("/dev/ttyUSB0", 57600), conf)
+
+	// flags are always sent, as they are stored in EEPROM
+	assert.Equal(t, conf.Commands(), []string{"8b", "212g", "31i", "1q", "0c"})
+
+	sentAt := time.Now().UTC()
+
+	// banner received before configuration
+	status, _ := parseStatusLine("[RF12demo.12] _ i1* g212 @ 868 MHz c0 q0")
+	gateway.statusReceived(status, sentAt.Add(-time.Second))
+	assert.False(t, gateway.checkConfig(sentAt))
+
+	// collect mode still set in EEPROM
+	status, _ = parseStatusLine("A i31* g212 @ 868 MHz c1 q1")
+	gateway.statusReceived(status, sentAt.Add(time.Second))
+	assert.False(t, gateway.checkConfig(sentAt))
+
+	// configuration reply
+	status, _ = parseStatusLine("A i31* g212 @ 868 MHz q1")
+	gateway.statusReceived(status, sentAt.Add(time.Second))
+	assert.True(t, gateway.checkConfig(sentAt))
+
+	gatewayStatus, verified := gateway.Status()
+	assert.True(t, verified)
+	assert.Equal(t, gatewayStatus, Rf12demoStatus{Version: 12, Configured: true, NodeId: 31, Group: 212, Band: 868, Quiet: true})
+
+	assert.NotNil(t, gateway.SendCommand("8b; rm -rf"))
+}
+
+func Test_RadioStats(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Config: &config.Config{}, Database: db}
+	handler := newRf12demoHandler(jeego)
+
+	gateway := NewGateway("default", serial_reader.NewTransport("/dev/ttyUSB0", 57600), nil)
+
+	at := time.Date(2014, 5, 1, 10, 0, 30, 0, time.UTC)
+
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "[RF12demo.12] _ i31* g212 @ 868 MHz c1 q0", at: at})
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 2 1 213 40 57 3", at: at})
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "? 2 1 213 40", at: at.Add(time.Second)})
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 3 3 18 113 49", at: at.Add(time.Minute)})
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "?", at: at.Add(time.Minute)})
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "? 12", at: at.Add(time.Minute)})
+
+	goodNb, badNb, history := gateway.RadioStats()
+	assert.Equal(t, goodNb, 2)
+	assert.Equal(t, badNb, 3)
+	assert.Equal(t, history, []RadioStatsMinute{
+		{At: time.Date(2014, 5, 1, 10, 0, 0, 0, time.UTC), Good: 1, Bad: 1},
+		{At: time.Date(2014, 5, 1, 10, 1, 0, 0, time.UTC), Good: 1, Bad: 2},
+	})
+
+	status, _ := gateway.Status()
+	assert.Equal(t, status.Version, 12)
+
+	// old minutes are forgotten
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 3 3 18 113 49", at: at.Add(time.Minute * RADIO_STATS_HISTORY)})
+
+	_, _, history = gateway.RadioStats()
+	assert.Equal(t, len(history), 2)
+	assert.Equal(t, history[0].At, time.Date(2014, 5, 1, 10, 1, 0, 0, time.UTC))
+}
+
+func Test_ParseLine(t *testing.T) {
+	dataLog, err := parseLine("OK 2 3 156 149 213 0")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.nodeId, 2)
+	assert.Equal(t, dataLog.nodeKind, 3)
+	assert.Equal(t, dataLog.data, []byte{156, 149, 213, 0})
+	assert.False(t, dataLog.hasRssi)
+
+	// with signal strength
+	dataLog, err = parseLine("OK 2 3 156 149 213 0 (-72)")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.data, []byte{156, 149, 213, 0})
+	assert.True(t, dataLog.hasRssi)
+	assert.Equal(t, dataLog.rssi, -72)
+
+	// hexadecimal form
+	dataLog, err = parseLine("OKX 02039C95D500")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.nodeId, 2)
+	assert.Equal(t, dataLog.nodeKind, 3)
+	assert.Equal(t, dataLog.data, []byte{156, 149, 213, 0})
+
+	dataLog, err = parseLine("OKX 0203 9C95 D500 (-101)")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.data, []byte{156, 149, 213, 0})
+	assert.Equal(t, dataLog.rssi, -101)
+
+	_, err = parseLine("OKX 02039Z")
+	assert.Equal(t, parseErrorKind(err), MALFORMED_TOKEN_ERROR)
+
+	_, err = parseLine("OK 2 131 156")
+	assert.Equal(t, parseErrorKind(err), RESERVED_BIT_ERROR)
+
+	_, err = parseLine("OK 2 3 15x6 149")
+	assert.Equal(t, parseErrorKind(err), MALFORMED_TOKEN_ERROR)
+
+	_, err = parseLine("OK 2 3 256 149")
+	assert.Equal(t, parseErrorKind(err), MALFORMED_TOKEN_ERROR)
+
+	_, err = parseLine("OKX 0203")
+	assert.Equal(t, parseErrorKind(err), BAD_LENGTH_ERROR)
+
+	_, err = parseLine("Garbage")
+	assert.Equal(t, parseErrorKind(err), GARBAGE_ERROR)
+
+	// ACK requested
+	dataLog, err = parseLine("OK 34 3 156 149 213 0")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.nodeId, 2)
+	assert.True(t, dataLog.ack)
+	assert.False(t, dataLog.dst)
+	assert.False(t, dataLog.ctl)
+
+	// addressed packet
+	dataLog, err = parseLine("OK 69 1 2 3")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.nodeId, 5)
+	assert.True(t, dataLog.dst)
+
+	// ACK packet, without node kind
+	dataLog, err = parseLine("OK 130")
+	assert.Nil(t, err)
+	assert.Equal(t, dataLog.nodeId, 2)
+	assert.True(t, dataLog.ctl)
+	assert.Equal(t, dataLog.data, []byte{})
+}
+
+// transport that records written commands
+type recordingTransport struct {
+	writtenChan chan string
+}
+
+func (transport *recordingTransport) Open() (io.ReadWriteCloser, error) {
+	return transport, nil
+}
+
+func (transport *recordingTransport) String() string {
+	return "recording"
+}
+
+func (transport *recordingTransport) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (transport *recordingTransport) Write(p []byte) (int, error) {
+	transport.writtenChan <- string(p)
+	return len(p), nil
+}
+
+func (transport *recordingTransport) Close() error {
+	return nil
+}
+
+func Test_HandleLineSendsPayloads(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Config: &config.Config{}, Database: db, Payloads: NewPayloadQueue()}
+	handler := newRf12demoHandler(jeego)
+
+	transport := &recordingTransport{writtenChan: make(chan string, 10)}
+
+	gateway := NewGateway("default", transport, &config.Rf12demoConfig{})
+	assert.Nil(t, gateway.Reader.Open())
+	go gateway.runCommandWriter()
+
+	at := time.Now().UTC()
+
+	// ACK already sent by sketch
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 35 3 18 113 49", at: at})
+	assert.Equal(t, len(transport.writtenChan), 0)
+
+	// rejected frame does not get payload
+	assert.Nil(t, jeego.Payloads.Push(3, []byte{1, 2, 255}))
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 35 3 18", at: at.Add(time.Second * 30)})
+	assert.Equal(t, len(jeego.Payloads.Pending(3)), 1)
+
+	// payload sent after each ACK, until dropped
+	for i := 1; i <= PAYLOAD_MAX_ATTEMPTS; i++ {
+		handler.handleLine(&Rf12demoLine{gateway: gateway, text: fmt.Sprintf("OK 35 3 18 113 %d", 49+i), at: at.Add(time.Minute * time.Duration(i))})
+		assert.Equal(t, <-transport.writtenChan, "1,2,255,3s\n")
+
+		// wait for attempt to be counted
+		for (len(jeego.Payloads.Pending(3)) > 0) && (jeego.Payloads.Pending(3)[0].Attempts < i) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	assert.Equal(t, len(jeego.Payloads.Pending(3)), 0)
+	assert.Equal(t, jeego.Payloads.Dropped(3), 1)
+
+	// no ACK requested
+	assert.Nil(t, jeego.Payloads.Push(3, []byte{4}))
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 3 3 18 113 60", at: at.Add(time.Minute * 10)})
+	assert.Equal(t, jeego.Payloads.Pending(3), []PendingPayload{{Data: []byte{4}, Attempts: 0}})
+
+	// addressed and control packets are not handled as node data
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 68 3 18 113 49", at: at})
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 132", at: at})
+	assert.Equal(t, len(db.nodes), 1)
+	assert.Equal(t, len(transport.writtenChan), 0)
+	assert.Equal(t, gateway.ParseErrors(), map[string]int{"bad_length": 1})
+}
+
+func Test_HandleDataLogAlarms(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Config: &config.Config{}, Database: db}
+
+	at := time.Now().UTC()
+
+	// no alarm
+	dataLog, _ := parseLine("OK 4 7 112 23")
+	dataLog.at = at
+	_, err := jeego.handleDataLog(dataLog)
+	assert.Nil(t, err)
+	assert.Equal(t, len(db.nodeLogs(db.NodeForId(4))), 0)
+
+	// leak alarm is logged immediately
+	dataLog, _ = parseLine("OK 4 7 113 23")
+	dataLog.at = at.Add(time.Second)
+	node, err := jeego.handleDataLog(dataLog)
+	assert.Nil(t, err)
+
+	nodeLogs := db.nodeLogs(node)
+	assert.Equal(t, len(nodeLogs), 1)
+	assert.Equal(t, nodeLogs[0].Values[LEAK_SENSOR], float64(1))
+
+	// still raised
+	dataLog, _ = parseLine("OK 4 7 113 23")
+	dataLog.at = at.Add(time.Second * 2)
+	jeego.handleDataLog(dataLog)
+	assert.Equal(t, len(db.nodeLogs(node)), 1)
+
+	// cleared
+	dataLog, _ = parseLine("OK 4 7 112 23")
+	dataLog.at = at.Add(time.Second * 3)
+	jeego.handleDataLog(dataLog)
+	assert.Equal(t, len(db.nodeLogs(node)), 2)
+}
+
+func Test_PayloadQueue(t *testing.T) {
+	queue := NewPayloadQueue()
+
+	assert.Nil(t, queue.Push(2, []byte{1}))
+	assert.Nil(t, queue.Push(2, []byte{2, 3}))
+
+	assert.NotNil(t, queue.Push(0, []byte{1}))
+	assert.NotNil(t, queue.Push(31, []byte{1}))
+	assert.NotNil(t, queue.Push(2, make([]byte, PAYLOAD_MAX_LENGTH+1)))
+
+	assert.Equal(t, len(queue.Pending(2)), 2)
+
+	// payload stays pending until sent PAYLOAD_MAX_ATTEMPTS times
+	payload := queue.next(2)
+	assert.Equal(t, payload.data, []byte{1})
+	for i := 1; i < PAYLOAD_MAX_ATTEMPTS; i++ {
+		assert.False(t, queue.sent(2, payload))
+	}
+	assert.Equal(t, queue.Pending(2), []PendingPayload{{Data: []byte{1}, Attempts: PAYLOAD_MAX_ATTEMPTS - 1}, {Data: []byte{2, 3}, Attempts: 0}})
+	assert.Equal(t, queue.Dropped(2), 0)
+
+	assert.True(t, queue.sent(2, payload))
+	assert.Equal(t, queue.next(2).data, []byte{2, 3})
+	assert.Equal(t, queue.Dropped(2), 1)
+
+	for i := 0; i < PAYLOAD_MAX_ATTEMPTS; i++ {
+		queue.sent(2, queue.next(2))
+	}
+	assert.Nil(t, queue.next(2))
+	assert.Equal(t, queue.Dropped(2), 2)
+
+	for i := 0; i < PAYLOAD_QUEUE_MAX; i++ {
+		assert.Nil(t, queue.Push(3, []byte{byte(i)}))
+	}
+	assert.NotNil(t, queue.Push(3, []byte{42}))
+}
+
+func Test_HandleLineRejectsInvalidData(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Config: &config.Config{}, Database: db}
+	handler := newRf12demoHandler(jeego)
+	handler.quarantineChan = make(chan string, 10)
+
+	gateway := NewGateway("ground", serial_reader.NewTransport("/dev/ttyUSB0", 57600), nil)
+
+	at := time.Now().UTC()
+
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 3 3 18 113 49", at: at})
+
+	// bad length does not touch existing node
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 3 1 156 149", at: at.Add(time.Second)})
+
+	// unknown kind does not create a node
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 5 42 1 2 3", at: at})
+
+	// malformed token
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "OK 3 3 18 1x3 49", at: at})
+
+	// garbage is not counted
+	handler.handleLine(&Rf12demoLine{gateway: gateway, text: "Available commands:", at: at})
+
+	assert.Equal(t, len(db.nodes), 1)
+
+	node := db.NodeForId(3)
+	assert.Equal(t, node.Kind, 3)
+	assert.Equal(t, node.LastSeenAt, at)
+
+	assert.Equal(t, gateway.ParseErrors(), map[string]int{"bad_length": 1, "unknown_kind": 1, "malformed_token": 1})
+
+	assert.Equal(t, len(handler.quarantineChan), 3)
+	assert.Equal(t, <-handler.quarantineChan, fmt.Sprintf("[%s] OK 3 1 156 149", at.Add(time.Second).Format(time.RFC3339)))
+}
+
+func Test_HandleDataLogKindChange(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Config: &config.Config{}, Database: db}
+
+	at := time.Now().UTC().Truncate(time.Second)
+
+	dataLog, _ := parseLine("OK 3 3 18 113 49")
+	dataLog.at = at
+	node, err := jeego.handleDataLog(dataLog)
+	assert.Nil(t, err)
+	assert.Equal(t, node.Values[TEMP_SENSOR], 27.4)
+
+	// a single frame of another kind is rejected
+	dataLog, _ = parseLine("OK 3 1 213 40 57 3")
+	dataLog.at = at.Add(time.Second)
+	_, err = jeego.handleDataLog(dataLog)
+	assert.Equal(t, parseErrorKind(err), KIND_MISMATCH_ERROR)
+	assert.Equal(t, node.Kind, TINYTX_T_NODE)
+	assert.Equal(t, node.LastSeenAt, at)
+	assert.Equal(t, node.Values[TEMP_SENSOR], 27.4)
+
+	// kind changes after several consistent frames
+	for i := 2; i <= KIND_CHANGE_FRAMES_NB; i++ {
+		dataLog, _ = parseLine("OK 3 1 213 40 57 3")
+		dataLog.at = at.Add(time.Second * time.Duration(i))
+		_, err = jeego.handleDataLog(dataLog)
+	}
+	assert.Nil(t, err)
+	assert.Equal(t, node.Kind, JEENODE_THLM_NODE)
+	assert.Equal(t, node.PreviousKind, TINYTX_T_NODE)
+	_, found := node.Values[MOTION_SENSOR]
+	assert.True(t, found)
+
+	changes := db.nodeKindChanges(node)
+	assert.Equal(t, len(changes), 1)
+	assert.Equal(t, *changes[0], NodeKindChange{NodeId: 3, At: at.Add(time.Second * KIND_CHANGE_FRAMES_NB), FromKind: TINYTX_T_NODE, ToKind: JEENODE_THLM_NODE})
+
+	// kind is persisted
+	db.loadNodes()
+	node = db.NodeForId(3)
+	assert.Equal(t, node.Kind, JEENODE_THLM_NODE)
+	assert.Equal(t, node.PreviousKind, TINYTX_T_NODE)
+}
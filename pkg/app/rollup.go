@@ -0,0 +1,243 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	log "code.google.com/p/log4go"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+// history resolutions
+const (
+	RAW_RESOLUTION    = "raw"    // node logs
+	HOURLY_RESOLUTION = "hourly" // hourly rollups
+	DAILY_RESOLUTION  = "daily"  // daily rollups
+)
+
+const (
+	RAW_MAX_SPAN    = 2  // in days, longer ranges are not fetched from node logs
+	HOURLY_MAX_SPAN = 62 // in days, longer ranges are not fetched from hourly rollups
+)
+
+// Rollup table and period
+type rollupDef struct {
+	table  string
+	period int64 // in seconds
+}
+
+// rollups definitions, by resolution
+var rollupDefs = map[string]rollupDef{
+	HOURLY_RESOLUTION: {table: "node_hourly_values", period: 3600},
+	DAILY_RESOLUTION:  {table: "node_daily_values", period: 86400},
+}
+
+// Sensor values aggregated over a period
+type RollupPoint struct {
+	At    time.Time `json:"at"` // period start
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Count int       `json:"count"` // number of logged values
+}
+
+// Returns history resolution to use for given time range
+//
+// The finest resolution that is not too verbose for that range, and that is still kept for range start, is picked.
+func pickResolution(from time.Time, to time.Time, now time.Time, retention config.RetentionConfig) string {
+	span := to.Sub(from)
+
+	if (span <= time.Hour*24*RAW_MAX_SPAN) && retained(from, now, retention.Logs) {
+		return RAW_RESOLUTION
+	}
+
+	if (span <= time.Hour*24*HOURLY_MAX_SPAN) && retained(from, now, retention.Hourly) {
+		return HOURLY_RESOLUTION
+	}
+
+	return DAILY_RESOLUTION
+}
+
+// helper
+func retained(at time.Time, now time.Time, days int) bool {
+	return (days == 0) || !at.Before(now.Add(-time.Hour*24*time.Duration(days)))
+}
+
+// Returns start of period that contains given time
+func (def rollupDef) periodStart(at int64) int64 {
+	return (at / def.period) * def.period
+}
+
+// Mark hour of given log time as to be aggregated, logsMutex must be locked
+func (db *Database) markRollupHour(at time.Time) {
+	if db.rollupHours == nil {
+		db.rollupHours = make(map[int64]bool)
+	}
+
+	db.rollupHours[rollupDefs[HOURLY_RESOLUTION].periodStart(at.Unix())] = true
+}
+
+// Mark hours of logs inserted since last hourly rollup as to be aggregated
+func (db *Database) loadRollupHours() {
+	var from int64
+	if err := db.driver.QueryRow("SELECT COALESCE(MAX(at), 0) FROM node_hourly_values").Scan(&from); err != nil {
+		panic(log.Critical(err))
+	}
+
+	rows, err := db.driver.Query("SELECT DISTINCT (at / 3600) * 3600 FROM node_log_values WHERE at >= ?", from)
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hour int64
+		rows.Scan(&hour)
+
+		db.markRollupHour(time.Unix(hour, 0).UTC())
+	}
+}
+
+// Update rollups of periods that received node logs since last update
+//
+// Only those periods are computed again, as a whole, as they may have been computed before their end, or before
+// older logs were replayed. Other periods are left untouched, as their logs may have been partly trimmed since.
+// Daily rollups are computed from hourly rollups, so that they don't depend on node logs retention.
+func (db *Database) updateRollups() {
+	db.logsMutex.Lock()
+	hours := db.rollupHours
+	db.rollupHours = nil
+	db.logsMutex.Unlock()
+
+	if len(hours) == 0 {
+		// nothing to aggregate
+		return
+	}
+
+	hourly := rollupDefs[HOURLY_RESOLUTION]
+	daily := rollupDefs[DAILY_RESOLUTION]
+
+	days := make(map[int64]bool)
+	for hour := range hours {
+		days[daily.periodStart(hour)] = true
+	}
+
+	// persist in database
+	for _, bounds := range periodsRanges(hours, hourly.period) {
+		db.writeQuery(&DatabaseQuery{
+			query: "INSERT OR REPLACE INTO node_hourly_values (node_id, sensor, at, min, max, avg, count) " +
+				"SELECT node_id, sensor, (at / 3600) * 3600, MIN(value), MAX(value), AVG(value), COUNT(value) FROM node_log_values " +
+				"WHERE at >= ? AND at < ? AND value IS NOT NULL " +
+				"GROUP BY node_id, sensor, at / 3600",
+			args: []interface{}{bounds[0], bounds[1]},
+		})
+	}
+
+	for _, bounds := range periodsRanges(days, daily.period) {
+		db.writeQuery(&DatabaseQuery{
+			query: "INSERT OR REPLACE INTO node_daily_values (node_id, sensor, at, min, max, avg, count) " +
+				"SELECT node_id, sensor, (at / 86400) * 86400, MIN(min), MAX(max), SUM(avg * count) / SUM(count), SUM(count) FROM node_hourly_values " +
+				"WHERE at >= ? AND at < ? " +
+				"GROUP BY node_id, sensor, at / 86400",
+			args: []interface{}{bounds[0], bounds[1]},
+		})
+	}
+}
+
+// Returns time ranges [start, end) of consecutive periods, sorted, given periods starts
+func periodsRanges(starts map[int64]bool, period int64) [][2]int64 {
+	sorted := make(periodsStarts, 0, len(starts))
+	for start := range starts {
+		sorted = append(sorted, start)
+	}
+
+	sort.Sort(sorted)
+
+	result := make([][2]int64, 0)
+	for _, start := range sorted {
+		if last := len(result) - 1; (last >= 0) && (result[last][1] == start) {
+			result[last][1] = start + period
+		} else {
+			result = append(result, [2]int64{start, start + period})
+		}
+	}
+
+	return result
+}
+
+// sort helper
+type periodsStarts []int64
+
+func (a periodsStarts) Len() int           { return len(a) }
+func (a periodsStarts) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a periodsStarts) Less(i, j int) bool { return a[i] < a[j] }
+
+// Delete rollups older than retention
+func (db *Database) trimRollups(retention config.RetentionConfig) {
+	for resolution, days := range map[string]int{HOURLY_RESOLUTION: retention.Hourly, DAILY_RESOLUTION: retention.Daily} {
+		if days == 0 {
+			// kept forever
+			continue
+		}
+
+		limit := time.Now().UTC().Add(-time.Hour * 24 * time.Duration(days)).Unix()
+
+		// persist in database
+		db.writeQuery(&DatabaseQuery{
+			query: fmt.Sprintf("DELETE FROM %s WHERE (at < ?)", rollupDefs[resolution].table),
+			args:  []interface{}{limit},
+		})
+	}
+}
+
+// Fetch sensor history for given node between given times, with given resolution
+//
+// With raw resolution, each point is a logged value. Otherwise, the period that contains from time is included.
+func (db *Database) sensorHistory(node *Node, sensor Sensor, resolution string, from time.Time, to time.Time) ([]*RollupPoint, error) {
+	var query string
+	start := from.Unix()
+
+	if resolution == RAW_RESOLUTION {
+		query = "SELECT at, value, value, value, 1 FROM node_log_values WHERE node_id=? AND sensor=? AND at >= ? AND at <= ? AND value IS NOT NULL ORDER BY at"
+	} else if def, found := rollupDefs[resolution]; found {
+		query = fmt.Sprintf("SELECT at, min, max, avg, count FROM %s WHERE node_id=? AND sensor=? AND at >= ? AND at <= ? ORDER BY at", def.table)
+
+		// include period that contains start time
+		start = def.periodStart(from.Unix())
+	} else {
+		return nil, fmt.Errorf("Unknown resolution: %s", resolution)
+	}
+
+	rows, err := db.driver.Query(query, node.Id, sensor.Name(), start, to.Unix())
+	if err != nil {
+		panic(log.Critical(err))
+	}
+	defer rows.Close()
+
+	result := make([]*RollupPoint, 0)
+
+	for rows.Next() {
+		var (
+			at    int64
+			min   float64
+			max   float64
+			avg   float64
+			count int
+		)
+
+		rows.Scan(&at, &min, &max, &avg, &count)
+
+		result = append(result, &RollupPoint{
+			At:    time.Unix(at, 0).UTC(),
+			Min:   min,
+			Max:   max,
+			Avg:   math.Floor(avg*100+0.5) / 100,
+			Count: count,
+		})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+func Test_PickResolution(t *testing.T) {
+	now := time.Date(2015, 3, 10, 12, 0, 0, 0, time.UTC)
+	retention := config.RetentionConfig{Logs: 2, Hourly: 365, Daily: 0}
+
+	assert.Equal(t, pickResolution(now.Add(-time.Hour*24), now, now, retention), RAW_RESOLUTION)
+	assert.Equal(t, pickResolution(now.Add(-time.Hour*24*7), now, now, retention), HOURLY_RESOLUTION)
+	assert.Equal(t, pickResolution(now.Add(-time.Hour*24*90), now, now, retention), DAILY_RESOLUTION)
+
+	// logs already trimmed
+	assert.Equal(t, pickResolution(now.Add(-time.Hour*24*5), now.Add(-time.Hour*24*4), now, retention), HOURLY_RESOLUTION)
+
+	// hourly rollups already trimmed
+	assert.Equal(t, pickResolution(now.Add(-time.Hour*24*400), now.Add(-time.Hour*24*399), now, retention), DAILY_RESOLUTION)
+
+	// logs kept forever
+	retention.Logs = 0
+	assert.Equal(t, pickResolution(now.Add(-time.Hour*24*5), now.Add(-time.Hour*24*4), now, retention), RAW_RESOLUTION)
+}
+
+func Test_UpdateRollups(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	node := db.InsertNode(2, TINYTX_TH_NODE)
+	at := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	insertValue := func(minutes int, value float64) {
+		node.setSensorValue(TEMP_SENSOR, value)
+		db.insertNodeLog(node, at.Add(time.Minute*time.Duration(minutes)))
+	}
+
+	insertValue(0, 20)
+	insertValue(30, 21)
+	insertValue(60, 23)
+
+	db.updateRollups()
+
+	points, err := db.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at, at.Add(time.Hour*2))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, *points[0], RollupPoint{At: at, Min: 20, Max: 21, Avg: 20.5, Count: 2})
+	assert.Equal(t, *points[1], RollupPoint{At: at.Add(time.Hour), Min: 23, Max: 23, Avg: 23, Count: 1})
+
+	// last hour is computed again
+	insertValue(90, 24)
+	insertValue(120, 18)
+
+	db.updateRollups()
+
+	points, err = db.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at, at.Add(time.Hour*2))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 3)
+	assert.Equal(t, *points[0], RollupPoint{At: at, Min: 20, Max: 21, Avg: 20.5, Count: 2})
+	assert.Equal(t, *points[1], RollupPoint{At: at.Add(time.Hour), Min: 23, Max: 24, Avg: 23.5, Count: 2})
+
+	// older logs are inserted after a rollups update, eg: by a replay
+	insertValue(-120, 16)
+	insertValue(-110, 17)
+
+	db.updateRollups()
+
+	points, err = db.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at.Add(-time.Hour*2), at.Add(time.Hour*2))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 4)
+	assert.Equal(t, *points[0], RollupPoint{At: at.Add(-time.Hour * 2), Min: 16, Max: 17, Avg: 16.5, Count: 2})
+	assert.Equal(t, *points[1], RollupPoint{At: at, Min: 20, Max: 21, Avg: 20.5, Count: 2})
+
+	// period that contains start time is included
+	points, err = db.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at.Add(time.Minute*30), at.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, points[0].At, at)
+
+	// daily average is weighted by values count
+	points, err = db.sensorHistory(node, TEMP_SENSOR, DAILY_RESOLUTION, at, at.Add(time.Hour*12))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 1)
+	assert.Equal(t, *points[0], RollupPoint{At: at.Add(-time.Hour * 12), Min: 16, Max: 24, Avg: 19.86, Count: 7})
+
+	// raw values
+	points, err = db.sensorHistory(node, TEMP_SENSOR, RAW_RESOLUTION, at.Add(time.Minute*30), at.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, *points[0], RollupPoint{At: at.Add(time.Minute * 30), Min: 21, Max: 21, Avg: 21, Count: 1})
+
+	// rollups outlive logs
+	db.trimNodeLogs(0)
+	db.trimRollups(config.RetentionConfig{Hourly: 0, Daily: 0})
+
+	points, err = db.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at, at.Add(time.Hour*2))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 3)
+
+	// no logs inserted since last update
+	db.updateRollups()
+
+	points, err = db.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at, at.Add(time.Hour*2))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 3)
+
+	db.trimRollups(config.RetentionConfig{Hourly: 1, Daily: 0})
+
+	points, err = db.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at, at.Add(time.Hour*2))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 0)
+
+	points, err = db.sensorHistory(node, TEMP_SENSOR, DAILY_RESOLUTION, at.Add(-time.Hour*12), at.Add(time.Hour*12))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 1)
+
+	_, err = db.sensorHistory(node, TEMP_SENSOR, "weekly", at, at.Add(time.Hour))
+	assert.NotNil(t, err)
+}
+
+func Test_LoadRollupHours(t *testing.T) {
+	dbFilename := TempFilename()
+
+	db := newTestDatabase(t, dbFilename)
+	defer destroyTestDatabase(db)
+
+	node := db.InsertNode(2, TINYTX_TH_NODE)
+	at := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	node.setSensorValue(TEMP_SENSOR, 20)
+	db.insertNodeLog(node, at)
+
+	// logs not aggregated before restart
+	db.close()
+	db2 := newTestDatabase(t, dbFilename)
+	defer destroyTestDatabase(db2)
+
+	db2.updateRollups()
+
+	points, err := db2.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at, at.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 1)
+	assert.Equal(t, *points[0], RollupPoint{At: at, Min: 20, Max: 20, Avg: 20, Count: 1})
+}
+
+func Test_UpdateRollupsPartlyTrimmedPeriods(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	node := db.InsertNode(2, TINYTX_TH_NODE)
+	at := time.Date(2015, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	insertValue := func(when time.Time, value float64) {
+		node.setSensorValue(TEMP_SENSOR, value)
+		db.insertNodeLog(node, when)
+	}
+
+	insertValue(at, 20)
+	insertValue(at.Add(time.Minute*30), 22)
+
+	db.updateRollups()
+
+	// first log of hour is trimmed
+	_, err := db.driver.Exec("DELETE FROM node_log_values WHERE at < ?", at.Add(time.Minute).Unix())
+	assert.Nil(t, err)
+
+	// older logs are replayed
+	insertValue(at.Add(-time.Hour*24*3), 15)
+
+	db.updateRollups()
+
+	points, err := db.sensorHistory(node, TEMP_SENSOR, HOURLY_RESOLUTION, at, at.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 1)
+	assert.Equal(t, *points[0], RollupPoint{At: at, Min: 20, Max: 22, Avg: 21, Count: 2})
+
+	points, err = db.sensorHistory(node, TEMP_SENSOR, DAILY_RESOLUTION, at.Add(-time.Hour*24*3), at)
+	assert.Nil(t, err)
+	assert.Equal(t, len(points), 2)
+	assert.Equal(t, *points[0], RollupPoint{At: at.Add(-time.Hour * (24*3 + 12)), Min: 15, Max: 15, Avg: 15, Count: 1})
+	assert.Equal(t, *points[1], RollupPoint{At: at.Add(-time.Hour * 12), Min: 20, Max: 22, Avg: 21, Count: 2})
+}
+
+func Test_PeriodsRanges(t *testing.T) {
+	starts := map[int64]bool{7200: true, 0: true, 3600: true, 14400: true}
+
+	assert.Equal(t, periodsRanges(starts, 3600), [][2]int64{{0, 10800}, {14400, 18000}})
+	assert.Equal(t, len(periodsRanges(map[int64]bool{}, 3600)), 0)
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"math"
+	"sort"
+
+	log "code.google.com/p/log4go"
+)
+
+// sensors kinds
+const (
+	TEMP_SENSOR       = iota // Temperature
+	HUMI_SENSOR              // Humidity
+	LIGHT_SENSOR             // Light
+	MOTION_SENSOR            // Motion
+	LOWBAT_SENSOR            // Low Battery
+	VCC_SENSOR               // Supply voltage
+	POWER_SENSOR             // Current usage
+	ENERGY_SENSOR            // Energy consumption
+	LEAK_SENSOR              // Water leak alarm
+	SMOKE_SENSOR             // Smoke alarm
+	CO2_SENSOR               // CO2 level
+	DEW_POINT_SENSOR         // Dew point (derived)
+	ABS_HUMI_SENSOR          // Absolute humidity (derived)
+	HEAT_INDEX_SENSOR        // Heat index (derived)
+)
+
+// sensor value types
+const (
+	FLOAT_VALUE = iota // eg: 21.3
+	INT_VALUE          // eg: 74
+	BOOL_VALUE         // eg: true
+)
+
+type Sensor uint
+
+// Sensor definition
+type SensorDef struct {
+	Name      string // used in API, database and node kinds definitions
+	ValueType int
+	Critical  bool // alarm that must be handled immediately
+	Discrete  bool // readings are recorded as timestamped events (cf. SensorEvent)
+}
+
+// registered sensors
+//
+// NB: initialized before init() functions, because node kinds definitions need them
+var SensorDefs = map[Sensor]*SensorDef{
+	TEMP_SENSOR:   {Name: "temperature", ValueType: FLOAT_VALUE},
+	HUMI_SENSOR:   {Name: "humidity", ValueType: INT_VALUE},
+	LIGHT_SENSOR:  {Name: "light", ValueType: INT_VALUE},
+	MOTION_SENSOR: {Name: "motion", ValueType: BOOL_VALUE, Discrete: true},
+	LOWBAT_SENSOR: {Name: "low_battery", ValueType: BOOL_VALUE},
+	VCC_SENSOR:    {Name: "vcc", ValueType: INT_VALUE},
+	POWER_SENSOR:  {Name: "power", ValueType: INT_VALUE},
+	ENERGY_SENSOR: {Name: "energy", ValueType: INT_VALUE},
+	LEAK_SENSOR:   {Name: "leak", ValueType: BOOL_VALUE, Critical: true, Discrete: true},
+	SMOKE_SENSOR:  {Name: "smoke", ValueType: BOOL_VALUE, Critical: true, Discrete: true},
+	CO2_SENSOR:    {Name: "co2", ValueType: INT_VALUE},
+
+	// derived sensors (cf. DerivedSensorDefs)
+	DEW_POINT_SENSOR:  {Name: "dew_point", ValueType: FLOAT_VALUE},
+	ABS_HUMI_SENSOR:   {Name: "absolute_humidity", ValueType: FLOAT_VALUE},
+	HEAT_INDEX_SENSOR: {Name: "heat_index", ValueType: FLOAT_VALUE},
+}
+
+// registered sensors, sorted by id
+var AllSensors = sortedSensors()
+
+// Register a new sensor
+func RegisterSensor(sensor Sensor, name string, valueType int) {
+	if SensorDefs[sensor] != nil {
+		panic(log.Critical("Sensor %d already registered", sensor))
+	}
+
+	if _, found := sensorForName(name); found {
+		panic(log.Critical("Sensor %s already registered", name))
+	}
+
+	SensorDefs[sensor] = &SensorDef{Name: name, ValueType: valueType}
+
+	AllSensors = sortedSensors()
+}
+
+// Returns registered sensors, sorted by id
+func sortedSensors() []Sensor {
+	result := make([]Sensor, 0)
+	for sensor := range SensorDefs {
+		result = append(result, sensor)
+	}
+
+	sort.Sort(sensorsById(result))
+
+	return result
+}
+
+// Returns sensor with given name
+func sensorForName(name string) (Sensor, bool) {
+	for sensor, def := range SensorDefs {
+		if def.Name == name {
+			return sensor, true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns sensor name
+func (sensor Sensor) Name() string {
+	if def := SensorDefs[sensor]; def != nil {
+		return def.Name
+	}
+
+	return ""
+}
+
+// Returns true if sensor is an alarm that must be handled immediately
+func (sensor Sensor) Critical() bool {
+	if def := SensorDefs[sensor]; def != nil {
+		return def.Critical
+	}
+
+	return false
+}
+
+// Returns true if sensor readings are recorded as timestamped events
+func (sensor Sensor) Discrete() bool {
+	if def := SensorDefs[sensor]; def != nil {
+		return def.Discrete
+	}
+
+	return false
+}
+
+// Normalize a value to store, according to sensor value type
+func (sensor Sensor) normalize(value float64) float64 {
+	switch SensorDefs[sensor].ValueType {
+	case INT_VALUE:
+		return math.Trunc(value)
+
+	case BOOL_VALUE:
+		if value != 0 {
+			return 1
+		}
+
+		return 0
+	}
+
+	return value
+}
+
+// Returns typed value, according to sensor value type
+func (sensor Sensor) typedValue(value float64) interface{} {
+	switch SensorDefs[sensor].ValueType {
+	case INT_VALUE:
+		return int64(value)
+
+	case BOOL_VALUE:
+		return (value != 0)
+	}
+
+	return value
+}
+
+// sort helper
+type sensorsById []Sensor
+
+func (a sensorsById) Len() int           { return len(a) }
+func (a sensorsById) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a sensorsById) Less(i, j int) bool { return a[i] < a[j] }
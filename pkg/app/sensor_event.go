@@ -0,0 +1,87 @@
+package app
+
+import (
+	"time"
+)
+
+const (
+	OCCUPANCY_TIMEOUT = 15 // in minutes, an occupancy period lasts that long after last motion
+	EVENTS_HISTORY    = 30 // in days
+)
+
+// Timestamped reading of a discrete sensor (cf. SensorDef.Discrete)
+type SensorEvent struct {
+	NodeId int
+	Sensor Sensor
+	At     time.Time
+	Value  float64
+}
+
+// Occupancy period, from first motion to last motion + timeout
+type OccupancyPeriod struct {
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Duration  float64   `json:"duration"` // in seconds
+	MotionsNb int       `json:"motions_nb"`
+}
+
+// Returns current values of enabled discrete sensors
+func (node *Node) discreteValues() map[Sensor]float64 {
+	result := make(map[Sensor]float64)
+
+	for _, sensor := range node.enabledSensors() {
+		if value, found := node.Values[sensor]; found && sensor.Discrete() {
+			result[sensor] = value
+		}
+	}
+
+	return result
+}
+
+// Returns events for discrete sensors readings received at given time
+//
+// An event is returned for each raised reading, and when a reading is cleared.
+func (node *Node) sensorEvents(previous map[Sensor]float64, at time.Time) []*SensorEvent {
+	result := make([]*SensorEvent, 0)
+
+	for sensor, value := range node.discreteValues() {
+		if previousValue, found := previous[sensor]; (value != 0) || (found && (value != previousValue)) {
+			result = append(result, &SensorEvent{NodeId: node.Id, Sensor: sensor, At: at, Value: value})
+		}
+	}
+
+	return result
+}
+
+// Compute occupancy periods from given motion events, sorted by time
+func computeOccupancy(events []*SensorEvent, timeout time.Duration) []*OccupancyPeriod {
+	result := make([]*OccupancyPeriod, 0)
+
+	var current *OccupancyPeriod
+
+	for _, event := range events {
+		if (event.Sensor != MOTION_SENSOR) || (event.Value == 0) {
+			continue
+		}
+
+		if (current == nil) || event.At.After(current.End) {
+			current = &OccupancyPeriod{Start: event.At}
+			result = append(result, current)
+		}
+
+		current.End = event.At.Add(timeout)
+		current.Duration = current.End.Sub(current.Start).Seconds()
+		current.MotionsNb += 1
+	}
+
+	return result
+}
+
+// cf. http://stackoverflow.com/a/17323212
+func (event *SensorEvent) toJsonifableMap() map[string]interface{} {
+	return map[string]interface{}{
+		"sensor": event.Sensor.Name(),
+		"at":     event.At,
+		"value":  event.Sensor.typedValue(event.Value),
+	}
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+func Test_ComputeOccupancy(t *testing.T) {
+	at := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+	timeout := time.Minute * OCCUPANCY_TIMEOUT
+
+	events := []*SensorEvent{
+		{Sensor: MOTION_SENSOR, At: at, Value: 1},
+		{Sensor: MOTION_SENSOR, At: at.Add(time.Minute * 5), Value: 1},
+		{Sensor: MOTION_SENSOR, At: at.Add(time.Minute * 6), Value: 0},
+		{Sensor: LEAK_SENSOR, At: at.Add(time.Minute * 7), Value: 1},
+		{Sensor: MOTION_SENSOR, At: at.Add(time.Minute * 15), Value: 1},
+		{Sensor: MOTION_SENSOR, At: at.Add(time.Hour), Value: 1},
+	}
+
+	periods := computeOccupancy(events, timeout)
+	assert.Equal(t, len(periods), 2)
+	assert.Equal(t, *periods[0], OccupancyPeriod{Start: at, End: at.Add(time.Minute * 30), Duration: 1800, MotionsNb: 3})
+	assert.Equal(t, *periods[1], OccupancyPeriod{Start: at.Add(time.Hour), End: at.Add(time.Hour + timeout), Duration: 900, MotionsNb: 1})
+
+	assert.Equal(t, len(computeOccupancy([]*SensorEvent{}, timeout)), 0)
+}
+
+func Test_HandleDataLogSensorEvents(t *testing.T) {
+	db := newTestDatabase(t, TempFilename())
+	defer destroyTestDatabase(db)
+
+	jeego := &Jeego{Config: &config.Config{}, Database: db}
+
+	at := time.Date(2015, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	handle := func(motion uint64, seconds int) {
+		node := &Node{Kind: JEENODE_THLM_NODE}
+		data := node.encodeData(map[Sensor]uint64{TEMP_SENSOR: 213, HUMI_SENSOR: 60, LIGHT_SENSOR: 100, MOTION_SENSOR: motion})
+
+		_, err := jeego.handleDataLog(&Rf12demoDataLog{nodeId: 2, nodeKind: JEENODE_THLM_NODE, data: data, at: at.Add(time.Second * time.Duration(seconds))})
+		assert.Nil(t, err)
+	}
+
+	handle(0, 0)
+	handle(1, 10)
+	handle(1, 20)
+	handle(0, 30)
+	handle(0, 40)
+
+	node := db.NodeForId(2)
+
+	events := db.nodeSensorEvents(node, "", at, at.Add(time.Minute))
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, *events[0], SensorEvent{NodeId: 2, Sensor: MOTION_SENSOR, At: at.Add(time.Second * 10), Value: 1})
+	assert.Equal(t, *events[1], SensorEvent{NodeId: 2, Sensor: MOTION_SENSOR, At: at.Add(time.Second * 20), Value: 1})
+	assert.Equal(t, *events[2], SensorEvent{NodeId: 2, Sensor: MOTION_SENSOR, At: at.Add(time.Second * 30), Value: 0})
+
+	assert.Equal(t, events[2].toJsonifableMap(), map[string]interface{}{"sensor": "motion", "at": at.Add(time.Second * 30), "value": false})
+
+	// time range and sensor
+	assert.Equal(t, len(db.nodeSensorEvents(node, "motion", at.Add(time.Second*15), at.Add(time.Minute))), 2)
+	assert.Equal(t, len(db.nodeSensorEvents(node, "leak", at, at.Add(time.Minute))), 0)
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Node sensor settings
+//
+// Shift correction is applied to values computed from node data with: value * gain + offset
+type SensorSettings struct {
+	Offset float64 `json:"offset"`
+	Gain   float64 `json:"gain"` // 1 if not set
+
+	// sensor is decoded but its values are ignored
+	Disabled bool `json:"disabled"`
+}
+
+// Returns gain
+func (settings *SensorSettings) gain() float64 {
+	if settings.Gain == 0 {
+		return 1
+	}
+
+	return settings.Gain
+}
+
+// Returns true if settings do not change anything
+func (settings *SensorSettings) isDefault() bool {
+	return (settings.Offset == 0) && (settings.gain() == 1) && !settings.Disabled
+}
+
+// Check settings for given sensor
+func (settings *SensorSettings) validate(sensor Sensor) error {
+	if (SensorDefs[sensor].ValueType == BOOL_VALUE) && (settings.Offset != 0 || settings.gain() != 1) {
+		return fmt.Errorf("Shift correction not supported by sensor: %s", sensor.Name())
+	}
+
+	return nil
+}
+
+// Apply shift correction to given value
+func (settings *SensorSettings) correct(value float64) float64 {
+	result := value*settings.gain() + settings.Offset
+
+	// get rid of floating point noise
+	return math.Floor(result*1e6+0.5) / 1e6
+}
+
+// Returns sensors of given settings, sorted by id
+func settingsSensors(settings map[Sensor]*SensorSettings) []Sensor {
+	result := make([]Sensor, 0)
+	for sensor := range settings {
+		result = append(result, sensor)
+	}
+
+	sort.Sort(sensorsById(result))
+
+	return result
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_SensorValues(t *testing.T) {
+	assert.Equal(t, Sensor(TEMP_SENSOR).Name(), "temperature")
+	assert.Equal(t, Sensor(LOWBAT_SENSOR).Name(), "low_battery")
+
+	sensor, found := sensorForName("humidity")
+	assert.True(t, found)
+	assert.Equal(t, sensor, Sensor(HUMI_SENSOR))
+
+	_, found = sensorForName("pressure")
+	assert.False(t, found)
+
+	assert.Equal(t, Sensor(TEMP_SENSOR).normalize(21.37), 21.37)
+	assert.Equal(t, Sensor(HUMI_SENSOR).normalize(74.9), float64(74))
+	assert.Equal(t, Sensor(MOTION_SENSOR).normalize(3), float64(1))
+
+	assert.Equal(t, Sensor(TEMP_SENSOR).typedValue(21.3), 21.3)
+	assert.Equal(t, Sensor(VCC_SENSOR).typedValue(3142), int64(3142))
+	assert.Equal(t, Sensor(MOTION_SENSOR).typedValue(1), true)
+}
+
+func Test_RegisterSensor(t *testing.T) {
+	RegisterSensor(Sensor(100), "test_sensor", FLOAT_VALUE)
+	defer func() {
+		delete(SensorDefs, Sensor(100))
+		AllSensors = sortedSensors()
+	}()
+
+	assert.Equal(t, AllSensors[len(AllSensors)-1], Sensor(100))
+
+	sensor, found := sensorForName("test_sensor")
+	assert.True(t, found)
+	assert.Equal(t, sensor, Sensor(100))
+
+	assert.Panics(t, func() { RegisterSensor(Sensor(101), "temperature", FLOAT_VALUE) })
+}
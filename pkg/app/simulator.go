@@ -0,0 +1,286 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "code.google.com/p/log4go"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+const (
+	SIMULATOR_PORT = "simulator"
+
+	SIMULATOR_MAX_NODE_ID     = 30
+	SIMULATOR_MOTION_BURST    = 5    // max number of frames in a motion burst
+	SIMULATOR_VCC_FULL        = 3300 // in mV
+	SIMULATOR_VCC_LOW         = 2200 // in mV
+	SIMULATOR_INTERVAL_JITTER = 0.1  // interval jitter ratio
+	SIMULATOR_POWER_MAX       = 3000 // in W
+	SIMULATOR_CO2_OUTDOOR     = 400  // in ppm
+)
+
+// Simulated node
+type SimulatedNode struct {
+	Id       int
+	Kind     int
+	Interval time.Duration
+
+	// simulated values
+	Temperature float64
+	Humidity    float64
+	Light       float64
+	Motion      bool
+	LowBattery  bool
+	Vcc         float64
+	Power       float64 // in W
+	Energy      float64 // in Wh
+	Co2         float64 // in ppm
+	Leak        bool
+	Smoke       bool
+
+	baseTemperature float64
+	motionFrames    int
+	nextAt          time.Time
+}
+
+// Virtual RF12demo gateway, that generates frames for simulated nodes
+//
+// It can be used as a gateway transport, or directly from tests with NextLine().
+type Simulator struct {
+	Nodes []*SimulatedNode
+
+	conf  config.SimulatorConfig
+	rand  *rand.Rand
+	now   time.Time
+	mutex sync.Mutex
+}
+
+// Instanciate a new simulator, with one node per node kind at least
+func NewSimulator(conf config.SimulatorConfig, seed int64) *Simulator {
+	result := &Simulator{
+		conf: conf,
+		rand: rand.New(rand.NewSource(seed)),
+		now:  time.Now().UTC(),
+	}
+
+	// sort node kinds to get a deterministic simulation
+	kinds := make([]int, 0)
+	for kind := range NodeKinds {
+		// stock payloads formats are only decoded for configured node ids
+		if kind <= NODE_KIND_MAX {
+			kinds = append(kinds, kind)
+		}
+	}
+	sort.Ints(kinds)
+
+	nodesNb := conf.NodesNb
+	if nodesNb < len(kinds) {
+		nodesNb = len(kinds)
+	}
+
+	if nodesNb > SIMULATOR_MAX_NODE_ID {
+		nodesNb = SIMULATOR_MAX_NODE_ID
+	}
+
+	interval := time.Second * time.Duration(conf.Interval)
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	for i := 0; i < nodesNb; i++ {
+		node := &SimulatedNode{
+			Id:              i + 1,
+			Kind:            kinds[i%len(kinds)],
+			Interval:        interval,
+			baseTemperature: 17 + result.rand.Float64()*5,
+			Humidity:        40 + result.rand.Float64()*20,
+			Vcc:             SIMULATOR_VCC_FULL - result.rand.Float64()*200,
+		}
+
+		node.Temperature = node.baseTemperature
+		node.Power = result.rand.Float64() * 500
+		node.Co2 = SIMULATOR_CO2_OUTDOOR + result.rand.Float64()*400
+		node.nextAt = result.now.Add(time.Duration(result.rand.Int63n(int64(interval))))
+
+		result.Nodes = append(result.Nodes, node)
+	}
+
+	return result
+}
+
+// Returns next simulated RF12demo line, and the simulated time it is received at
+func (sim *Simulator) NextLine() (string, time.Time) {
+	sim.mutex.Lock()
+	defer sim.mutex.Unlock()
+
+	// pick next node to transmit
+	var node *SimulatedNode
+	for _, simNode := range sim.Nodes {
+		if (node == nil) || simNode.nextAt.Before(node.nextAt) {
+			node = simNode
+		}
+	}
+
+	sim.now = node.nextAt
+
+	sim.updateNode(node)
+
+	jitter := (sim.rand.Float64()*2 - 1) * SIMULATOR_INTERVAL_JITTER
+	node.nextAt = sim.now.Add(node.Interval + time.Duration(jitter*float64(node.Interval)))
+
+	return node.line(), sim.now
+}
+
+// Update simulated node values
+func (sim *Simulator) updateNode(node *SimulatedNode) {
+	// temperature drifts around its base value
+	node.Temperature += (sim.rand.Float64()-0.5)*0.4 + (node.baseTemperature-node.Temperature)*0.1
+	node.Temperature = math.Max(-50, math.Min(50, node.Temperature))
+
+	// humidity drifts too
+	node.Humidity += (sim.rand.Float64() - 0.5) * 2
+	node.Humidity = math.Max(0, math.Min(100, node.Humidity))
+
+	// light follows daylight
+	hour := float64(sim.now.Hour()) + float64(sim.now.Minute())/60
+	node.Light = math.Max(0, math.Sin((hour-6)*math.Pi/12)) * 100
+
+	// motion bursts
+	if node.motionFrames > 0 {
+		node.motionFrames -= 1
+	} else if sim.rand.Float64() < sim.conf.MotionProbability {
+		node.motionFrames = 1 + sim.rand.Intn(SIMULATOR_MOTION_BURST)
+	}
+	node.Motion = (node.motionFrames > 0)
+
+	// power drifts, and energy is consumed since last frame
+	if !node.nextAt.IsZero() {
+		node.Energy += node.Power * node.Interval.Hours()
+	}
+	node.Power = math.Max(0, math.Min(SIMULATOR_POWER_MAX, node.Power+(sim.rand.Float64()-0.5)*100))
+
+	// CO2 level drifts, higher with motion
+	node.Co2 += (sim.rand.Float64()-0.5)*50 + (SIMULATOR_CO2_OUTDOOR-node.Co2)*0.05
+	if node.Motion {
+		node.Co2 += 20
+	}
+	node.Co2 = math.Max(SIMULATOR_CO2_OUTDOOR, node.Co2)
+
+	// alarms are as rare as low battery events, and last for one frame
+	node.Leak = sim.rand.Float64() < sim.conf.LowbatProbability
+	node.Smoke = sim.rand.Float64() < sim.conf.LowbatProbability
+
+	// battery slowly discharges, with sudden low battery events
+	node.Vcc = math.Max(SIMULATOR_VCC_LOW, node.Vcc-sim.rand.Float64())
+
+	if !node.LowBattery && (sim.rand.Float64() < sim.conf.LowbatProbability) {
+		node.LowBattery = true
+		node.Vcc = SIMULATOR_VCC_LOW
+	}
+}
+
+// Returns raw sensor values to transmit, computed with node kind definition
+func (node *SimulatedNode) rawValues() map[Sensor]uint64 {
+	result := make(map[Sensor]uint64)
+
+	values := map[Sensor]float64{
+		TEMP_SENSOR:   node.Temperature,
+		HUMI_SENSOR:   math.Floor(node.Humidity),
+		LIGHT_SENSOR:  node.Light,
+		MOTION_SENSOR: boolToValue(node.Motion),
+		LOWBAT_SENSOR: boolToValue(node.LowBattery),
+		VCC_SENSOR:    math.Floor(node.Vcc),
+		POWER_SENSOR:  math.Floor(node.Power),
+		ENERGY_SENSOR: math.Floor(node.Energy),
+		CO2_SENSOR:    math.Floor(node.Co2),
+		LEAK_SENSOR:   boolToValue(node.Leak),
+		SMOKE_SENSOR:  boolToValue(node.Smoke),
+	}
+
+	kind := NodeKinds[node.Kind]
+	if kind == nil {
+		return result
+	}
+
+	for _, field := range kind.Fields {
+		result[field.sensor] = field.rawValue(values[field.sensor])
+	}
+
+	return result
+}
+
+// Returns RF12demo line for current node values
+func (node *SimulatedNode) line() string {
+	data := (&Node{Kind: node.Kind}).encodeData(node.rawValues())
+
+	result := fmt.Sprintf("OK %d %d", node.Id&0x1f, node.Kind&0x7f)
+	for _, b := range data {
+		result += fmt.Sprintf(" %d", b)
+	}
+
+	return result
+}
+
+// helper
+func boolToValue(val bool) float64 {
+	if val {
+		return 1
+	}
+
+	return 0
+}
+
+//
+// Transport interface (cf. serial_reader.Transport)
+//
+
+// Open a new connection to simulated gateway: lines are generated at simulated speed
+func (sim *Simulator) Open() (io.ReadWriteCloser, error) {
+	reader, writer := io.Pipe()
+
+	sim.mutex.Lock()
+	sim.now = time.Now().UTC()
+	for _, node := range sim.Nodes {
+		node.nextAt = sim.now.Add(time.Duration(sim.rand.Int63n(int64(node.Interval))))
+	}
+	sim.mutex.Unlock()
+
+	go func() {
+		// loop until connection is closed
+		for {
+			line, at := sim.NextLine()
+
+			time.Sleep(at.Sub(time.Now().UTC()))
+
+			if _, err := writer.Write([]byte(line + "\r\n")); err != nil {
+				log.Debug("Simulator connection closed: %s", err)
+				return
+			}
+		}
+	}()
+
+	return &simulatorConn{reader}, nil
+}
+
+func (sim *Simulator) String() string {
+	return SIMULATOR_PORT
+}
+
+// Connection to simulated gateway
+type simulatorConn struct {
+	*io.PipeReader
+}
+
+// Commands sent to simulated gateway are ignored
+func (conn *simulatorConn) Write(p []byte) (int, error) {
+	log.Debug("Simulator received: %s", strings.TrimSpace(string(p)))
+	return len(p), nil
+}
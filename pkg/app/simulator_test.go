@@ -0,0 +1,129 @@
+package app
+
+import (
+	"bufio"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/aymerick/jeego/pkg/config"
+)
+
+func newTestSimulator() *Simulator {
+	return NewSimulator(config.SimulatorConfig{NodesNb: 8, Interval: 60, MotionProbability: 0.2, LowbatProbability: 0.01}, 42)
+}
+
+func Test_SimulatorNodes(t *testing.T) {
+	sim := newTestSimulator()
+
+	assert.Equal(t, len(sim.Nodes), 8)
+
+	// all node kinds are simulated, except stock payloads formats
+	kinds := make(map[int]bool)
+	for _, simNode := range sim.Nodes {
+		kinds[simNode.Kind] = true
+	}
+
+	for kind := range NodeKinds {
+		assert.Equal(t, kinds[kind], kind <= NODE_KIND_MAX, "Node kind %d", kind)
+	}
+}
+
+func Test_SimulatorLines(t *testing.T) {
+	sim := newTestSimulator()
+
+	lastAt := time.Time{}
+
+	for i := 0; i < 500; i++ {
+		line, at := sim.NextLine()
+
+		assert.False(t, at.Before(lastAt), "Simulated time goes backward")
+		lastAt = at
+
+		dataLog, err := parseLine(line)
+		if err != nil {
+			t.Fatalf("Failed to parse simulated line %q: %s", line, err)
+		}
+
+		var simNode *SimulatedNode
+		for _, n := range sim.Nodes {
+			if n.Id == dataLog.nodeId {
+				simNode = n
+			}
+		}
+
+		if simNode == nil {
+			t.Fatalf("Unknown simulated node: %d", dataLog.nodeId)
+		}
+
+		assert.Equal(t, dataLog.nodeKind, simNode.Kind)
+
+		node := &Node{Id: dataLog.nodeId, Kind: dataLog.nodeKind}
+		assert.Equal(t, len(dataLog.data), node.expectedDataLength())
+
+		node.HandleData(dataLog.data)
+
+		if node.haveSensor(TEMP_SENSOR) {
+			temperature := node.Values[TEMP_SENSOR]
+			assert.True(t, math.Abs(temperature-simNode.Temperature) <= 0.05, "Bad temperature: %v / %v", temperature, simNode.Temperature)
+		}
+
+		if node.haveSensor(HUMI_SENSOR) {
+			assert.Equal(t, node.sensorValue(HUMI_SENSOR), int64(simNode.Humidity))
+		}
+
+		if node.haveSensor(LIGHT_SENSOR) {
+			assert.True(t, math.Abs(node.Values[LIGHT_SENSOR]-simNode.Light) < 2, "Bad light: %v / %v", node.Values[LIGHT_SENSOR], simNode.Light)
+		}
+
+		if node.haveSensor(MOTION_SENSOR) {
+			assert.Equal(t, node.sensorValue(MOTION_SENSOR), simNode.Motion)
+		}
+
+		if node.haveSensor(LOWBAT_SENSOR) {
+			assert.Equal(t, node.sensorValue(LOWBAT_SENSOR), simNode.LowBattery)
+		}
+
+		if node.haveSensor(VCC_SENSOR) {
+			assert.Equal(t, node.sensorValue(VCC_SENSOR), int64(simNode.Vcc))
+		}
+
+		if node.haveSensor(POWER_SENSOR) {
+			assert.Equal(t, node.sensorValue(POWER_SENSOR), int64(simNode.Power))
+			assert.Equal(t, node.sensorValue(ENERGY_SENSOR), int64(simNode.Energy))
+		}
+
+		if node.haveSensor(CO2_SENSOR) {
+			assert.Equal(t, node.sensorValue(CO2_SENSOR), int64(simNode.Co2))
+		}
+
+		if node.haveSensor(LEAK_SENSOR) {
+			assert.Equal(t, node.sensorValue(LEAK_SENSOR), simNode.Leak)
+		}
+
+		if node.haveSensor(SMOKE_SENSOR) {
+			assert.Equal(t, node.sensorValue(SMOKE_SENSOR), simNode.Smoke)
+		}
+	}
+}
+
+func Test_SimulatorTransport(t *testing.T) {
+	sim := NewSimulator(config.SimulatorConfig{NodesNb: 1, Interval: 0}, 42)
+	for _, simNode := range sim.Nodes {
+		simNode.Interval = time.Millisecond
+	}
+
+	conn, err := sim.Open()
+	if err != nil {
+		t.Fatal("Failed to open simulator:", err)
+	}
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Nil(t, err)
+
+	_, err = parseLine(line[:len(line)-2])
+	assert.Nil(t, err)
+}
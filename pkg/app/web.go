@@ -7,7 +7,9 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
+	"time"
 
 	log "code.google.com/p/log4go"
 	"github.com/bmizerany/pat"
@@ -15,7 +17,30 @@ import (
 )
 
 type NodeJSON struct {
-	Node Node `json:"node"`
+	Node NodeUpdateJSON `json:"node"`
+}
+
+type NodeUpdateJSON struct {
+	Name string `json:"name"`
+
+	// sensors settings, by sensor name (unchanged if not set)
+	Settings map[string]*SensorSettings `json:"settings"`
+}
+
+type GatewayCommandJSON struct {
+	Command string `json:"command"`
+}
+
+type CalibrationJSON struct {
+	Calibration struct {
+		Nodes           []int    `json:"nodes"`
+		ReferenceNodeId int      `json:"reference_node_id"`
+		Sensors         []string `json:"sensors"`
+	} `json:"calibration"`
+}
+
+type NodePayloadJSON struct {
+	Data []int `json:"data"`
 }
 
 // helper
@@ -110,18 +135,47 @@ func wrapHandlerUpdateNode(jeego *Jeego, meth string) http.HandlerFunc {
 				if node == nil {
 					respondsWithError(w, http.StatusNotFound, fmt.Errorf("Node %d not found", nodeId))
 				} else {
-					// update node
-					node.Name = nodeJSON.Node.Name
+					err = updateNodeSettings(node, nodeJSON.Node.Settings)
+					if err != nil {
+						respondsWithError(w, http.StatusBadRequest, err)
+					} else {
+						// update node
+						node.Name = nodeJSON.Node.Name
 
-					jeego.Database.UpdateNode(node)
+						jeego.Database.UpdateNode(node)
 
-					respondsWithJSON(w, map[string]interface{}{"node": node.toJsonifableMap()})
+						if nodeJSON.Node.Settings != nil {
+							jeego.Database.UpdateNodeSettings(node)
+						}
+
+						respondsWithJSON(w, map[string]interface{}{"node": node.toJsonifableMap()})
+					}
 				}
 			}
 		}
 	}
 }
 
+// helper
+func updateNodeSettings(node *Node, settingsJSON map[string]*SensorSettings) error {
+	if settingsJSON == nil {
+		return nil
+	}
+
+	settings := make(map[Sensor]*SensorSettings)
+
+	for name, sensorSettings := range settingsJSON {
+		sensor, found := sensorForName(name)
+		if !found {
+			return fmt.Errorf("Unknown sensor: %s", name)
+		}
+
+		settings[sensor] = sensorSettings
+	}
+
+	return node.setSensorsSettings(settings)
+}
+
 // GET /api/nodes/:id/temperatures
 func wrapHandlerNodeTemperatures(jeego *Jeego, meth string) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
@@ -175,6 +229,435 @@ func wrapHandlerNodeLogs(jeego *Jeego, meth string) http.HandlerFunc {
 	}
 }
 
+// GET /api/nodes/:id/kind_changes
+func wrapHandlerNodeKindChanges(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		// parse node id
+		nodeId, err := strconv.Atoi(req.URL.Query().Get(":id"))
+		if err != nil {
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else {
+			// get node
+			node := jeego.Database.NodeForId(nodeId)
+			if node != nil {
+				respondsWithJSON(w, map[string]interface{}{"kind_changes": jeego.Database.nodeKindChanges(node)})
+			} else {
+				respondsWithError(w, http.StatusNotFound, fmt.Errorf("Node %d not found", nodeId))
+			}
+		}
+	}
+}
+
+// helper: parse "from" and "to" query parameters (RFC3339), last 24 hours by default
+func parseTimeRange(req *http.Request) (time.Time, time.Time, error) {
+	to := time.Now().UTC()
+	if param := req.URL.Query().Get("to"); param != "" {
+		var err error
+		if to, err = time.Parse(time.RFC3339, param); err != nil {
+			return to, to, err
+		}
+	}
+
+	from := to.Add(-time.Hour * 24)
+	if param := req.URL.Query().Get("from"); param != "" {
+		var err error
+		if from, err = time.Parse(time.RFC3339, param); err != nil {
+			return from, to, err
+		}
+	}
+
+	return from, to, nil
+}
+
+// GET /api/nodes/:id/events
+func wrapHandlerNodeEvents(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		// parse node id
+		nodeId, err := strconv.Atoi(req.URL.Query().Get(":id"))
+		if err != nil {
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else if from, to, err := parseTimeRange(req); err != nil {
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else {
+			// get node
+			node := jeego.Database.NodeForId(nodeId)
+			if node != nil {
+				events := jeego.Database.nodeSensorEvents(node, req.URL.Query().Get("sensor"), from, to)
+				result := make([]interface{}, len(events))
+
+				for index, event := range events {
+					result[index] = event.toJsonifableMap()
+				}
+
+				respondsWithJSON(w, map[string]interface{}{"events": result})
+			} else {
+				respondsWithError(w, http.StatusNotFound, fmt.Errorf("Node %d not found", nodeId))
+			}
+		}
+	}
+}
+
+// GET /api/nodes/:id/occupancy
+func wrapHandlerNodeOccupancy(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		// parse node id
+		nodeId, err := strconv.Atoi(req.URL.Query().Get(":id"))
+		if err != nil {
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else if from, to, err := parseTimeRange(req); err != nil {
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else {
+			// get node
+			node := jeego.Database.NodeForId(nodeId)
+			if node == nil {
+				respondsWithError(w, http.StatusNotFound, fmt.Errorf("Node %d not found", nodeId))
+			} else if !node.haveSensor(MOTION_SENSOR) {
+				respondsWithError(w, http.StatusBadRequest, fmt.Errorf("Node %d does not have sensor: %s", nodeId, Sensor(MOTION_SENSOR).Name()))
+			} else {
+				timeout := time.Minute * OCCUPANCY_TIMEOUT
+
+				// include period in progress at start time
+				events := jeego.Database.nodeSensorEvents(node, Sensor(MOTION_SENSOR).Name(), from.Add(-timeout), to)
+
+				respondsWithJSON(w, map[string]interface{}{"occupancy": computeOccupancy(events, timeout)})
+			}
+		}
+	}
+}
+
+// GET /api/nodes/:id/history
+func wrapHandlerNodeHistory(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		// parse node id
+		nodeId, err := strconv.Atoi(req.URL.Query().Get(":id"))
+		if err != nil {
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else if from, to, err := parseTimeRange(req); err != nil {
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else {
+			// get node
+			node := jeego.Database.NodeForId(nodeId)
+			sensor, found := sensorForName(req.URL.Query().Get("sensor"))
+
+			if node == nil {
+				respondsWithError(w, http.StatusNotFound, fmt.Errorf("Node %d not found", nodeId))
+			} else if !found || !node.haveSensor(sensor) {
+				respondsWithError(w, http.StatusBadRequest, fmt.Errorf("Node %d does not have sensor: %s", nodeId, req.URL.Query().Get("sensor")))
+			} else {
+				resolution := req.URL.Query().Get("resolution")
+				if resolution == "" {
+					resolution = pickResolution(from, to, time.Now().UTC(), jeego.Config.Retention)
+				}
+
+				if points, err := jeego.Database.sensorHistory(node, sensor, resolution, from, to); err != nil {
+					respondsWithError(w, http.StatusBadRequest, err)
+				} else {
+					respondsWithJSON(w, map[string]interface{}{"resolution": resolution, "points": points})
+				}
+			}
+		}
+	}
+}
+
+// GET /api/gateways
+func wrapHandlerGateways(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		result := make([]interface{}, len(jeego.Gateways))
+
+		for index, gateway := range jeego.Gateways {
+			result[index] = gateway.toJsonifableMap()
+		}
+
+		respondsWithJSON(w, map[string]interface{}{"gateways": result})
+	}
+}
+
+// GET /api/batteries
+func wrapHandlerBatteries(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		respondsWithJSON(w, map[string]interface{}{"batteries": jeego.BatteriesStatus()})
+	}
+}
+
+// GET /api/node_kinds
+func wrapHandlerNodeKinds(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		kinds := make([]int, 0)
+		for kind := range NodeKinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Ints(kinds)
+
+		result := make([]*NodeKind, len(kinds))
+		for index, kind := range kinds {
+			result[index] = NodeKinds[kind]
+		}
+
+		respondsWithJSON(w, map[string]interface{}{"node_kinds": result})
+	}
+}
+
+// POST /api/gateways/:name/commands
+func wrapHandlerGatewayCommand(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		// parse JSON
+		var commandJSON GatewayCommandJSON
+		err := json.NewDecoder(req.Body).Decode(&commandJSON)
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to parse JSON: %v", err))
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else {
+			// get gateway
+			name := req.URL.Query().Get(":name")
+
+			gateway := jeego.GatewayForName(name)
+			if gateway == nil {
+				respondsWithError(w, http.StatusNotFound, fmt.Errorf("Gateway %s not found", name))
+			} else {
+				// send command
+				err = gateway.SendCommand(commandJSON.Command)
+				if err != nil {
+					respondsWithError(w, http.StatusBadRequest, err)
+				} else {
+					respondsWithJSON(w, map[string]interface{}{"command": commandJSON.Command})
+				}
+			}
+		}
+	}
+}
+
+// GET /api/nodes/:id/payloads
+func wrapHandlerNodePayloads(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		// parse node id
+		nodeId, err := strconv.Atoi(req.URL.Query().Get(":id"))
+		if err != nil {
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else {
+			result := make([]map[string]interface{}, 0)
+			for _, payload := range jeego.Payloads.Pending(nodeId) {
+				result = append(result, map[string]interface{}{
+					"data":     intsFromBytes(payload.Data),
+					"attempts": payload.Attempts,
+				})
+			}
+
+			respondsWithJSON(w, map[string]interface{}{"payloads": result, "dropped_nb": jeego.Payloads.Dropped(nodeId)})
+		}
+	}
+}
+
+// POST /api/nodes/:id/payloads
+func wrapHandlerQueueNodePayload(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		// parse JSON
+		var payloadJSON NodePayloadJSON
+		err := json.NewDecoder(req.Body).Decode(&payloadJSON)
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to parse JSON: %v", err))
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else {
+			// parse node id
+			nodeId, err := strconv.Atoi(req.URL.Query().Get(":id"))
+			if err != nil {
+				respondsWithError(w, http.StatusBadRequest, err)
+			} else {
+				data, err := bytesFromInts(payloadJSON.Data)
+				if err == nil {
+					// queue payload until node requests an ACK
+					err = jeego.Payloads.Push(nodeId, data)
+				}
+
+				if err != nil {
+					respondsWithError(w, http.StatusBadRequest, err)
+				} else {
+					respondsWithJSON(w, map[string]interface{}{"pending_nb": len(jeego.Payloads.Pending(nodeId))})
+				}
+			}
+		}
+	}
+}
+
+// GET /api/calibration
+func wrapHandlerCalibration(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		jeego.calibrationMutex.Lock()
+		defer jeego.calibrationMutex.Unlock()
+
+		calibration := jeego.Calibration
+		if calibration == nil {
+			respondsWithError(w, http.StatusNotFound, fmt.Errorf("No calibration session"))
+		} else {
+			results := calibration.Results(jeego.Database)
+
+			respondsWithJSON(w, map[string]interface{}{"calibration": calibration.toJsonifableMap(results)})
+		}
+	}
+}
+
+// POST /api/calibration
+func wrapHandlerStartCalibration(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		jeego.calibrationMutex.Lock()
+		defer jeego.calibrationMutex.Unlock()
+
+		// parse JSON
+		var calibrationJSON CalibrationJSON
+		err := json.NewDecoder(req.Body).Decode(&calibrationJSON)
+		if err != nil {
+			log.Error(fmt.Sprintf("Failed to parse JSON: %v", err))
+			respondsWithError(w, http.StatusBadRequest, err)
+		} else if (jeego.Calibration != nil) && jeego.Calibration.Running() {
+			respondsWithError(w, http.StatusBadRequest, fmt.Errorf("A calibration session is already running"))
+		} else {
+			sensors := make([]Sensor, 0)
+			for _, name := range calibrationJSON.Calibration.Sensors {
+				sensor, found := sensorForName(name)
+				if !found {
+					err = fmt.Errorf("Unknown sensor: %s", name)
+					break
+				}
+
+				sensors = append(sensors, sensor)
+			}
+
+			var calibration *Calibration
+			if err == nil {
+				calibration, err = NewCalibration(jeego.Database, calibrationJSON.Calibration.Nodes, calibrationJSON.Calibration.ReferenceNodeId, sensors, time.Now().UTC())
+			}
+
+			if err != nil {
+				respondsWithError(w, http.StatusBadRequest, err)
+			} else {
+				log.Info("Calibration session started for nodes: %v", calibration.NodesIds)
+
+				jeego.Calibration = calibration
+
+				respondsWithJSON(w, map[string]interface{}{"calibration": calibration.toJsonifableMap([]*CalibrationResult{})})
+			}
+		}
+	}
+}
+
+// POST /api/calibration/stop
+func wrapHandlerStopCalibration(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		jeego.calibrationMutex.Lock()
+		defer jeego.calibrationMutex.Unlock()
+
+		calibration := jeego.Calibration
+		if calibration == nil {
+			respondsWithError(w, http.StatusNotFound, fmt.Errorf("No calibration session"))
+		} else {
+			calibration.Stop(time.Now().UTC())
+
+			results := calibration.Results(jeego.Database)
+
+			respondsWithJSON(w, map[string]interface{}{"calibration": calibration.toJsonifableMap(results)})
+		}
+	}
+}
+
+// POST /api/calibration/apply
+func wrapHandlerApplyCalibration(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		jeego.calibrationMutex.Lock()
+		defer jeego.calibrationMutex.Unlock()
+
+		calibration := jeego.Calibration
+		if calibration == nil {
+			respondsWithError(w, http.StatusNotFound, fmt.Errorf("No calibration session"))
+		} else if calibration.Running() {
+			respondsWithError(w, http.StatusBadRequest, fmt.Errorf("Calibration session must be stopped first"))
+		} else {
+			results := calibration.Results(jeego.Database)
+
+			err := calibration.Apply(jeego.Database, results)
+			if err != nil {
+				respondsWithError(w, http.StatusBadRequest, err)
+			} else {
+				log.Info("Calibration applied to nodes: %v", calibration.NodesIds)
+
+				jeego.Calibration = nil
+
+				respondsWithJSON(w, map[string]interface{}{"calibration": calibration.toJsonifableMap(results)})
+			}
+		}
+	}
+}
+
+// DELETE /api/calibration
+func wrapHandlerCancelCalibration(jeego *Jeego, meth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		addAccessControlHeaders(w, meth)
+
+		jeego.calibrationMutex.Lock()
+		defer jeego.calibrationMutex.Unlock()
+
+		if jeego.Calibration == nil {
+			respondsWithError(w, http.StatusNotFound, fmt.Errorf("No calibration session"))
+		} else {
+			jeego.Calibration = nil
+
+			respondsWithJSON(w, map[string]interface{}{})
+		}
+	}
+}
+
+// helper
+func bytesFromInts(values []int) ([]byte, error) {
+	result := make([]byte, len(values))
+
+	for index, value := range values {
+		if (value < 0) || (value > 255) {
+			return nil, fmt.Errorf("Invalid byte value: %d", value)
+		}
+
+		result[index] = byte(value)
+	}
+
+	return result, nil
+}
+
+// helper
+func intsFromBytes(data []byte) []int {
+	result := make([]int, len(data))
+
+	for index, b := range data {
+		result[index] = int(b)
+	}
+
+	return result
+}
+
 // websocket upgrader
 var wsUpgrader = &websocket.Upgrader{
 	ReadBufferSize:  1024,
@@ -263,6 +746,57 @@ func RunWebServer(jeego *Jeego) {
 		mux.Options("/api/nodes/:id/logs", wrapHandlerOptions(jeego, nodeLogsMeth))
 		mux.Get("/api/nodes/:id/logs", wrapHandlerNodeLogs(jeego, nodeLogsMeth))
 
+		nodeKindChangesMeth := "OPTIONS, GET"
+		mux.Options("/api/nodes/:id/kind_changes", wrapHandlerOptions(jeego, nodeKindChangesMeth))
+		mux.Get("/api/nodes/:id/kind_changes", wrapHandlerNodeKindChanges(jeego, nodeKindChangesMeth))
+
+		nodeEventsMeth := "OPTIONS, GET"
+		mux.Options("/api/nodes/:id/events", wrapHandlerOptions(jeego, nodeEventsMeth))
+		mux.Get("/api/nodes/:id/events", wrapHandlerNodeEvents(jeego, nodeEventsMeth))
+
+		nodeOccupancyMeth := "OPTIONS, GET"
+		mux.Options("/api/nodes/:id/occupancy", wrapHandlerOptions(jeego, nodeOccupancyMeth))
+		mux.Get("/api/nodes/:id/occupancy", wrapHandlerNodeOccupancy(jeego, nodeOccupancyMeth))
+
+		nodeHistoryMeth := "OPTIONS, GET"
+		mux.Options("/api/nodes/:id/history", wrapHandlerOptions(jeego, nodeHistoryMeth))
+		mux.Get("/api/nodes/:id/history", wrapHandlerNodeHistory(jeego, nodeHistoryMeth))
+
+		nodePayloadsMeth := "OPTIONS, GET, POST"
+		mux.Options("/api/nodes/:id/payloads", wrapHandlerOptions(jeego, nodePayloadsMeth))
+		mux.Get("/api/nodes/:id/payloads", wrapHandlerNodePayloads(jeego, nodePayloadsMeth))
+		mux.Post("/api/nodes/:id/payloads", wrapHandlerQueueNodePayload(jeego, nodePayloadsMeth))
+
+		batteriesMeth := "OPTIONS, GET"
+		mux.Options("/api/batteries", wrapHandlerOptions(jeego, batteriesMeth))
+		mux.Get("/api/batteries", wrapHandlerBatteries(jeego, batteriesMeth))
+
+		nodeKindsMeth := "OPTIONS, GET"
+		mux.Options("/api/node_kinds", wrapHandlerOptions(jeego, nodeKindsMeth))
+		mux.Get("/api/node_kinds", wrapHandlerNodeKinds(jeego, nodeKindsMeth))
+
+		calibrationMeth := "OPTIONS, GET, POST, DELETE"
+		mux.Options("/api/calibration", wrapHandlerOptions(jeego, calibrationMeth))
+		mux.Get("/api/calibration", wrapHandlerCalibration(jeego, calibrationMeth))
+		mux.Post("/api/calibration", wrapHandlerStartCalibration(jeego, calibrationMeth))
+		mux.Del("/api/calibration", wrapHandlerCancelCalibration(jeego, calibrationMeth))
+
+		calibrationStopMeth := "OPTIONS, POST"
+		mux.Options("/api/calibration/stop", wrapHandlerOptions(jeego, calibrationStopMeth))
+		mux.Post("/api/calibration/stop", wrapHandlerStopCalibration(jeego, calibrationStopMeth))
+
+		calibrationApplyMeth := "OPTIONS, POST"
+		mux.Options("/api/calibration/apply", wrapHandlerOptions(jeego, calibrationApplyMeth))
+		mux.Post("/api/calibration/apply", wrapHandlerApplyCalibration(jeego, calibrationApplyMeth))
+
+		gatewaysMeth := "OPTIONS, GET"
+		mux.Options("/api/gateways", wrapHandlerOptions(jeego, gatewaysMeth))
+		mux.Get("/api/gateways", wrapHandlerGateways(jeego, gatewaysMeth))
+
+		gatewayCommandsMeth := "OPTIONS, POST"
+		mux.Options("/api/gateways/:name/commands", wrapHandlerOptions(jeego, gatewayCommandsMeth))
+		mux.Post("/api/gateways/:name/commands", wrapHandlerGatewayCommand(jeego, gatewayCommandsMeth))
+
 		http.Handle("/api/", mux)
 
 		// Web Socket endpoint
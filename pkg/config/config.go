@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
@@ -19,6 +20,8 @@ import (
 //   - Jeelink on Mac: /dev/tty.usbserial-A1014IM4
 //   - Jeelink on Raspberry: /dev/ttyUSB0
 //   - Jeenode on Raspberry FTDI: /dev/ttyAMA0 (cf. http://jeelabs.org/2012/09/20/serial-hookup-jeenode-to-raspberry-pi/)
+//   - Jeelink shared on network with ser2net: tcp://attic-pi:2000
+//   - Built-in simulator: simulator
 const defaultConfig = `
 {
 	"serial_port": "/dev/ttyUSB0",
@@ -27,7 +30,19 @@ const defaultConfig = `
 	"log_level": "info",
 	"log_file": "stdout",
 	"database_path": "./jeego.db",
-	"web_server_port": 3000
+	"web_server_port": 3000,
+	"dedup_window": 2000,
+	"retention": {
+		"logs": 2,
+		"hourly": 365,
+		"daily": 0
+	},
+	"simulator": {
+		"nodes_nb": 5,
+		"interval": 60,
+		"motion_probability": 0.05,
+		"lowbat_probability": 0.001
+	}
 }
 `
 
@@ -44,6 +59,114 @@ type Config struct {
 	WebServerPort      int    `json:"web_server_port"`
 	WebAppPath         string `json:"web_app_path"`
 	Rf12demoLogFile    string `json:"rf12demo_log_file"`
+
+	Rf12demoQuarantineFile string `json:"rf12demo_quarantine_file"` // rejected lines
+	NodeKindsFile          string `json:"node_kinds_file"`          // additional node kinds definitions
+
+	Gateways    []GatewayConfig `json:"gateways"`
+	DedupWindow int             `json:"dedup_window"` // in milliseconds
+	Rf12demo    *Rf12demoConfig `json:"rf12demo"`     // default RF12demo sketch configuration
+
+	Plausibility map[string]*PlausibilityRule `json:"plausibility"`  // sensors plausibility rules, by sensor name
+	NodeDecoders []NodeDecoderConfig          `json:"node_decoders"` // payload formats of nodes that don't send a node kind byte
+
+	Retention RetentionConfig `json:"retention"`
+
+	Simulator SimulatorConfig `json:"simulator"`
+}
+
+// Gateway configuration (when several gateways are used)
+type GatewayConfig struct {
+	Name     string          `json:"name"`
+	Port     string          `json:"port"`
+	Baud     int             `json:"baud"`
+	Rf12demo *Rf12demoConfig `json:"rf12demo"`
+}
+
+// RF12demo sketch configuration, sent to gateway at startup
+//
+// Zero band, group and node id are not sent. Flags are always sent, as RF12demo stores them in EEPROM.
+type Rf12demoConfig struct {
+	Band    int  `json:"band"` // 4: 433 MHz, 8: 868 MHz, 9: 915 MHz
+	Group   int  `json:"group"`
+	NodeId  int  `json:"node_id"`
+	Quiet   bool `json:"quiet"`   // don't report bad packets
+	Collect bool `json:"collect"` // don't send ACKs, jeego can't answer ACK requests in time either
+}
+
+// Returns RF12demo commands for that configuration
+func (conf *Rf12demoConfig) Commands() []string {
+	result := make([]string, 0)
+
+	if conf.Band != 0 {
+		result = append(result, fmt.Sprintf("%db", conf.Band))
+	}
+
+	if conf.Group != 0 {
+		result = append(result, fmt.Sprintf("%dg", conf.Group))
+	}
+
+	if conf.NodeId != 0 {
+		result = append(result, fmt.Sprintf("%di", conf.NodeId))
+	}
+
+	if conf.Quiet {
+		result = append(result, "1q")
+	} else {
+		result = append(result, "0q")
+	}
+
+	if conf.Collect {
+		result = append(result, "1c")
+	} else {
+		result = append(result, "0c")
+	}
+
+	return result
+}
+
+// Returns frequency in MHz for configured band, or 0 if unknown
+func (conf *Rf12demoConfig) BandMHz() int {
+	switch conf.Band {
+	case 4:
+		return 433
+	case 8:
+		return 868
+	case 9:
+		return 915
+	}
+
+	return 0
+}
+
+// Payload format of a node that does not send a node kind byte, eg: a stock JeeLib roomNode
+type NodeDecoderConfig struct {
+	NodeId  int    `json:"node_id"`
+	Decoder string `json:"decoder"` // eg: roomnode, tinytx
+}
+
+// Retention of node logs and rollups, in days (0 to keep them forever)
+type RetentionConfig struct {
+	Logs   int `json:"logs"`
+	Hourly int `json:"hourly"`
+	Daily  int `json:"daily"`
+}
+
+// Plausibility rule for a sensor: incoming values that break it are rejected
+//
+// Nil bounds and zero rate are not checked.
+type PlausibilityRule struct {
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	MaxRate float64  `json:"max_rate,omitempty"` // maximum change per minute
+}
+
+// Simulator configuration (used when serial port is "simulator")
+type SimulatorConfig struct {
+	NodesNb           int     `json:"nodes_nb"`
+	Interval          int     `json:"interval"`           // in seconds
+	MotionProbability float64 `json:"motion_probability"` // probability for a frame to start a motion burst
+	LowbatProbability float64 `json:"lowbat_probability"` // probability for a frame to trigger a low battery event
 }
 
 // Load config from conf file
@@ -1,47 +1,256 @@
 package serial_reader
 
 import (
+	"errors"
 	"io"
+	"strings"
+	"sync"
+	"time"
 
 	log "code.google.com/p/log4go"
-	"github.com/chimera/rs232"
 )
 
 const (
 	LF_CHAR = 10
+
+	RECONNECT_MIN_DELAY = 1  // in seconds
+	RECONNECT_MAX_DELAY = 60 // in seconds
 )
 
 // Serial port reader
 type SerialReader struct {
 	io.ReadWriteCloser
+
+	transport Transport
+
+	// reconnection backoff
+	minDelay time.Duration
+	maxDelay time.Duration
+
+	// called each time serial port is opened
+	OnConnect func()
+
+	writeMutex sync.Mutex
+
+	mutex          sync.RWMutex
+	connected      bool
+	connectedAt    time.Time
+	disconnectedAt time.Time
+	lastError      error
+}
+
+// Serial port reader state
+type State struct {
+	Connected      bool
+	ConnectedAt    time.Time
+	DisconnectedAt time.Time
+	LastError      error
 }
 
-// Instanciate a serial port reader
+// Instanciate a reader for given port (cf. NewTransport())
+//
+// The port is not opened until Open(), OpenWithRetry() or Run() is called.
 func New(port string, baud int) *SerialReader {
-	options := rs232.Options{BitRate: uint32(baud), DataBits: 8, StopBits: 1}
-	ser, err := rs232.Open(port, options)
+	return NewWithTransport(NewTransport(port, baud))
+}
+
+// Instanciate a reader using given transport
+func NewWithTransport(transport Transport) *SerialReader {
+	return &SerialReader{
+		transport: transport,
+		minDelay:  time.Second * RECONNECT_MIN_DELAY,
+		maxDelay:  time.Second * RECONNECT_MAX_DELAY,
+	}
+}
+
+// Port name
+func (serial_reader *SerialReader) Port() string {
+	return serial_reader.transport.String()
+}
+
+// Open serial port
+func (serial_reader *SerialReader) Open() error {
+	ser, err := serial_reader.transport.Open()
+
+	serial_reader.mutex.Lock()
+	defer serial_reader.mutex.Unlock()
 
 	if err != nil {
-		panic(log.Critical(err))
+		serial_reader.lastError = err
+		return err
+	}
+
+	serial_reader.ReadWriteCloser = ser
+	serial_reader.connected = true
+	serial_reader.connectedAt = time.Now().UTC()
+	serial_reader.lastError = nil
+
+	return nil
+}
+
+// Open serial port, retrying with an exponential backoff until it succeeds
+func (serial_reader *SerialReader) OpenWithRetry() {
+	delay := serial_reader.minDelay
+
+	for {
+		err := serial_reader.Open()
+		if err == nil {
+			log.Info("Gateway connected: %s", serial_reader.Port())
+
+			if serial_reader.OnConnect != nil {
+				go serial_reader.OnConnect()
+			}
+
+			return
+		}
+
+		log.Warn("Failed to open %s, retrying in %v: %s", serial_reader.Port(), delay, err)
+
+		time.Sleep(delay)
+
+		delay *= 2
+		if delay > serial_reader.maxDelay {
+			delay = serial_reader.maxDelay
+		}
 	}
+}
 
-	return &SerialReader{ser}
+// Close serial port
+func (serial_reader *SerialReader) Close() error {
+	serial_reader.mutex.Lock()
+	defer serial_reader.mutex.Unlock()
+
+	return serial_reader.close()
+}
+
+// Close serial port, mutex must be locked
+func (serial_reader *SerialReader) close() error {
+	var err error
+
+	if serial_reader.ReadWriteCloser != nil {
+		err = serial_reader.ReadWriteCloser.Close()
+		serial_reader.ReadWriteCloser = nil
+	}
+
+	if serial_reader.connected {
+		serial_reader.connected = false
+		serial_reader.disconnectedAt = time.Now().UTC()
+	}
+
+	return err
+}
+
+// Mark serial port as disconnected after a failure
+func (serial_reader *SerialReader) disconnect(err error) {
+	serial_reader.mutex.Lock()
+	defer serial_reader.mutex.Unlock()
+
+	serial_reader.lastError = err
+	serial_reader.close()
+}
+
+// Returns true if serial port is currently opened
+func (serial_reader *SerialReader) Connected() bool {
+	serial_reader.mutex.RLock()
+	defer serial_reader.mutex.RUnlock()
+
+	return serial_reader.connected
+}
+
+// Returns current serial port state
+func (serial_reader *SerialReader) State() State {
+	serial_reader.mutex.RLock()
+	defer serial_reader.mutex.RUnlock()
+
+	return State{
+		Connected:      serial_reader.connected,
+		ConnectedAt:    serial_reader.connectedAt,
+		DisconnectedAt: serial_reader.disconnectedAt,
+		LastError:      serial_reader.lastError,
+	}
 }
 
 // Read a line from serial port
-func (serial_reader *SerialReader) ReadLine() string {
+//
+// On failure the serial port is closed and marked as disconnected.
+func (serial_reader *SerialReader) ReadLine() (string, error) {
+	serial_reader.mutex.RLock()
+	ser := serial_reader.ReadWriteCloser
+	serial_reader.mutex.RUnlock()
+
+	if ser == nil {
+		return "", errors.New("Serial port is not opened")
+	}
+
 	result := make([]byte, 0)
 	lastRead := make([]byte, 1)
 
 	// read byte by byte until the Line Feed character
 	for lastRead[0] != LF_CHAR {
-		n, err := serial_reader.Read(lastRead)
+		n, err := ser.Read(lastRead)
 		if (err != nil) || (n != 1) {
-			panic(log.Critical(err))
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+
+			serial_reader.disconnect(err)
+
+			return "", err
 		}
 
 		result = append(result, lastRead[0])
 	}
 
-	return string(result)
+	return string(result), nil
+}
+
+// Write a command to serial port
+//
+// Commands are terminated by a Line Feed character, and concurrent writes are serialized.
+func (serial_reader *SerialReader) WriteCommand(cmd string) error {
+	serial_reader.writeMutex.Lock()
+	defer serial_reader.writeMutex.Unlock()
+
+	serial_reader.mutex.RLock()
+	ser := serial_reader.ReadWriteCloser
+	serial_reader.mutex.RUnlock()
+
+	if ser == nil {
+		return errors.New("Serial port is not opened")
+	}
+
+	log.Debug("Sending command to %s: %s", serial_reader.Port(), cmd)
+
+	_, err := ser.Write([]byte(cmd + "\n"))
+
+	return err
+}
+
+// Read lines forever and send them to given channel
+//
+// The serial port is (re)opened with backoff whenever it is not available.
+func (serial_reader *SerialReader) Run(outputChan chan<- string) {
+	log.Info("Reading on serial port: %+v", serial_reader.Port())
+
+	// loop forever
+	for {
+		if !serial_reader.Connected() {
+			serial_reader.OpenWithRetry()
+		}
+
+		// read a line and trim it
+		line, err := serial_reader.ReadLine()
+		if err != nil {
+			log.Error("Gateway disconnected: %s: %s", serial_reader.Port(), err)
+			continue
+		}
+
+		line = strings.Trim(line, " \n\r")
+		if line != "" {
+			log.Debug("Received: %s", line)
+
+			// send line to handler
+			outputChan <- line
+		}
+	}
 }
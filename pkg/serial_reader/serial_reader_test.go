@@ -0,0 +1,135 @@
+package serial_reader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fake serial port, that fails when all data have been read
+type fakePort struct {
+	*bytes.Buffer
+	closed bool
+}
+
+func (port *fakePort) Close() error {
+	port.closed = true
+	return nil
+}
+
+// fake transport, that cycles on given openers
+type fakeTransport struct {
+	openers []func() (io.ReadWriteCloser, error)
+	nbCalls int
+}
+
+func (transport *fakeTransport) Open() (io.ReadWriteCloser, error) {
+	opener := transport.openers[transport.nbCalls%len(transport.openers)]
+	transport.nbCalls += 1
+	return opener()
+}
+
+func (transport *fakeTransport) String() string {
+	return "/dev/fake"
+}
+
+func newTestSerialReader(transport Transport) *SerialReader {
+	result := NewWithTransport(transport)
+	result.minDelay = time.Millisecond
+	result.maxDelay = time.Millisecond * 5
+
+	return result
+}
+
+func newTestFakeReader(openers ...func() (io.ReadWriteCloser, error)) *SerialReader {
+	return newTestSerialReader(&fakeTransport{openers: openers})
+}
+
+func readTestLine(t *testing.T, outputChan chan string) string {
+	select {
+	case line := <-outputChan:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("Timeout while waiting for line")
+	}
+
+	return ""
+}
+
+func Test_ReadLine(t *testing.T) {
+	port := &fakePort{Buffer: bytes.NewBufferString("OK 2 3 156 149 213 0\r\n")}
+
+	reader := newTestFakeReader(func() (io.ReadWriteCloser, error) { return port, nil })
+
+	assert.Nil(t, reader.Open())
+	assert.True(t, reader.Connected())
+
+	line, err := reader.ReadLine()
+	assert.Nil(t, err)
+	assert.Equal(t, line, "OK 2 3 156 149 213 0\r\n")
+
+	// port is unplugged
+	_, err = reader.ReadLine()
+	assert.NotNil(t, err)
+	assert.False(t, reader.Connected())
+	assert.True(t, port.closed)
+
+	state := reader.State()
+	assert.False(t, state.DisconnectedAt.IsZero())
+	assert.Equal(t, state.LastError, err)
+}
+
+func Test_RunReconnects(t *testing.T) {
+	failing := func() (io.ReadWriteCloser, error) {
+		return nil, errors.New("no such file or directory")
+	}
+
+	working := func() (io.ReadWriteCloser, error) {
+		return &fakePort{Buffer: bytes.NewBufferString("OK 2 3 156 149 213 0\n\n")}, nil
+	}
+
+	reader := newTestFakeReader(failing, failing, working)
+
+	outputChan := make(chan string)
+	go reader.Run(outputChan)
+
+	// same line is received again after reconnection
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, readTestLine(t, outputChan), "OK 2 3 156 149 213 0")
+	}
+}
+
+func Test_WriteCommand(t *testing.T) {
+	port := &fakePort{Buffer: bytes.NewBufferString("")}
+
+	reader := newTestFakeReader(func() (io.ReadWriteCloser, error) { return port, nil })
+
+	assert.NotNil(t, reader.WriteCommand("8b"))
+
+	reader.Open()
+
+	assert.Nil(t, reader.WriteCommand("8b"))
+	assert.Nil(t, reader.WriteCommand("212g"))
+	assert.Equal(t, port.String(), "8b\n212g\n")
+}
+
+func Test_OnConnect(t *testing.T) {
+	reader := newTestFakeReader(func() (io.ReadWriteCloser, error) {
+		return &fakePort{Buffer: bytes.NewBufferString("")}, nil
+	})
+
+	connectedChan := make(chan bool)
+	reader.OnConnect = func() { connectedChan <- true }
+
+	reader.OpenWithRetry()
+
+	select {
+	case <-connectedChan:
+	case <-time.After(time.Second):
+		t.Fatal("OnConnect not called")
+	}
+}
@@ -0,0 +1,87 @@
+package serial_reader
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/chimera/rs232"
+)
+
+const (
+	TCP_SCHEME = "tcp://"
+
+	TCP_DIAL_TIMEOUT = 10 // in seconds
+	TCP_KEEP_ALIVE   = 30 // in seconds
+)
+
+// Transport used to reach a RF12demo gateway
+type Transport interface {
+	// Open a new connection to gateway
+	Open() (io.ReadWriteCloser, error)
+
+	// Human readable transport description
+	String() string
+}
+
+// Returns the transport corresponding to given port
+//
+// Port can be:
+//   - a serial device, eg: /dev/ttyUSB0
+//   - a TCP address, eg: tcp://attic-pi:2000 (cf. ser2net)
+func NewTransport(port string, baud int) Transport {
+	if strings.HasPrefix(port, TCP_SCHEME) {
+		return &TcpTransport{Address: strings.TrimPrefix(port, TCP_SCHEME)}
+	}
+
+	return &SerialTransport{Port: port, Baud: baud}
+}
+
+// Serial port transport
+type SerialTransport struct {
+	Port string
+	Baud int
+}
+
+// Open serial port
+func (transport *SerialTransport) Open() (io.ReadWriteCloser, error) {
+	options := rs232.Options{BitRate: uint32(transport.Baud), DataBits: 8, StopBits: 1}
+
+	ser, err := rs232.Open(transport.Port, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return ser, nil
+}
+
+func (transport *SerialTransport) String() string {
+	return transport.Port
+}
+
+// TCP transport (eg: serial port shared with ser2net)
+type TcpTransport struct {
+	Address string
+}
+
+// Open TCP connection
+func (transport *TcpTransport) Open() (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("tcp", transport.Address, time.Second*TCP_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+
+	// detect a vanished remote host
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(time.Second * TCP_KEEP_ALIVE)
+	}
+
+	return conn, nil
+}
+
+func (transport *TcpTransport) String() string {
+	return fmt.Sprintf("%s%s", TCP_SCHEME, transport.Address)
+}
@@ -0,0 +1,48 @@
+package serial_reader
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewTransport(t *testing.T) {
+	transport := NewTransport("/dev/ttyUSB0", 57600)
+	assert.Equal(t, transport, &SerialTransport{Port: "/dev/ttyUSB0", Baud: 57600})
+	assert.Equal(t, transport.String(), "/dev/ttyUSB0")
+
+	transport = NewTransport("tcp://attic-pi:2000", 57600)
+	assert.Equal(t, transport, &TcpTransport{Address: "attic-pi:2000"})
+	assert.Equal(t, transport.String(), "tcp://attic-pi:2000")
+}
+
+func Test_TcpTransportReconnects(t *testing.T) {
+	// fake gateway
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for _, line := range []string{"OK 2 3 156 149 213 0\r\n", "OK 3 4 18 201 184 24\r\n"} {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			// send one line and hang up
+			conn.Write([]byte(line))
+			conn.Close()
+		}
+	}()
+
+	reader := newTestSerialReader(NewTransport("tcp://"+listener.Addr().String(), 0))
+
+	outputChan := make(chan string)
+	go reader.Run(outputChan)
+
+	assert.Equal(t, readTestLine(t, outputChan), "OK 2 3 156 149 213 0")
+	assert.Equal(t, readTestLine(t, outputChan), "OK 3 4 18 201 184 24")
+}